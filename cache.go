@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 缓存目录结构：
+//
+//	缓存/发布记录/      发布记录列表页面，联网抓取时每次都会刷新
+//	缓存/<发布日期>/    该版本的所有页面，如 缓存/2020-11-06/
+//
+// 每个子目录下的页面文件沿用 colly 的 sha1 前两位/sha1 结构，链接列表 文件记录缓存过的链接
+const (
+	indexCacheNamespace = "发布记录"
+	cacheManifestName   = "链接列表"
+	// 版本子目录名称的日期格式
+	releaseDateLayout = "2006-01-02"
+)
+
+// 与 colly 相同的缓存文件路径
+func cacheFilePath(dir string, url string) string {
+	sum := sha1.Sum([]byte(url))
+	hash := hex.EncodeToString(sum[:])
+	return filepath.Join(dir, hash[:2], hash)
+}
+
+// 把 fromDir 中某个链接的缓存移动到 toDir，覆盖 toDir 中原有的缓存
+func moveCacheEntry(fromDir string, toDir string, url string) error {
+	target := cacheFilePath(toDir, url)
+	if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		return err
+	}
+	if err := os.Rename(cacheFilePath(fromDir, url), target); err != nil {
+		return err
+	}
+	recordCachedURL(toDir, url)
+	return nil
+}
+
+// 各缓存目录中已经记录过的链接
+var cacheManifests = struct {
+	sync.Mutex
+	known map[string]map[string]bool
+}{known: make(map[string]map[string]bool)}
+
+// 把抓取过的链接追加到缓存目录的链接列表中
+func recordCachedURL(dir string, url string) {
+	cacheManifests.Lock()
+	defer cacheManifests.Unlock()
+	known, ok := cacheManifests.known[dir]
+	if !ok {
+		known = make(map[string]bool)
+		for _, u := range readCacheManifest(dir) {
+			known[u] = true
+		}
+		cacheManifests.known[dir] = known
+	}
+	if known[url] {
+		return
+	}
+	known[url] = true
+	f, err := os.OpenFile(filepath.Join(dir, cacheManifestName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.WriteString(url + "\n")
+}
+
+func readCacheManifest(dir string) []string {
+	f, err := os.Open(filepath.Join(dir, cacheManifestName))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	urls := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if u := strings.TrimSpace(scanner.Text()); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// 一条缓存记录
+type CacheEntry struct {
+	// 所属缓存子目录，为发布日期或 发布记录，旧版本直接放在根目录的为空
+	Namespace string
+	// 链接列表中没有记录的缓存文件为空
+	Url     string
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// 列出缓存根目录下的所有缓存文件
+func ListCacheEntries(cacheDir string) ([]CacheEntry, error) {
+	entries := make([]CacheEntry, 0)
+	namespaces, err := cacheNamespaces(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, namespace := range namespaces {
+		dir := filepath.Join(cacheDir, namespace)
+		urls := make(map[string]string)
+		for _, u := range readCacheManifest(dir) {
+			urls[cacheFilePath(dir, u)] = u
+		}
+		files, err := filepath.Glob(filepath.Join(dir, "[0-9a-f][0-9a-f]", "*"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil || info.IsDir() || strings.HasSuffix(file, "~") {
+				continue
+			}
+			entries = append(entries, CacheEntry{
+				Namespace: namespace,
+				Url:       urls[file],
+				Path:      file,
+				Size:      info.Size(),
+				ModTime:   info.ModTime(),
+			})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Namespace != entries[j].Namespace {
+			return entries[i].Namespace < entries[j].Namespace
+		}
+		return entries[i].Url < entries[j].Url
+	})
+	return entries, nil
+}
+
+// 缓存根目录本身(旧版本的缓存)和所有版本子目录
+func cacheNamespaces(cacheDir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(cacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	namespaces := []string{""}
+	for _, info := range infos {
+		if info.IsDir() && !isCacheHashDir(info.Name()) {
+			namespaces = append(namespaces, info.Name())
+		}
+	}
+	return namespaces, nil
+}
+
+// colly 的缓存文件按 sha1 前两位分目录
+func isCacheHashDir(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// 删除发布日期早于 release 的版本缓存目录以及根目录下不属于任何版本的旧缓存
+// release 须为 2006-01-02 格式的发布日期，发布记录目录以及其它不是发布日期的目录不受影响
+func PruneCacheBefore(cacheDir string, release string) (removed int, err error) {
+	before, err := time.Parse(releaseDateLayout, release)
+	if err != nil {
+		return 0, fmt.Errorf("发布日期 %q 格式错误，应为 %s", release, releaseDateLayout)
+	}
+	namespaces, err := cacheNamespaces(cacheDir)
+	if err != nil {
+		return 0, err
+	}
+	for _, namespace := range namespaces {
+		if namespace != "" {
+			date, err := time.Parse(releaseDateLayout, namespace)
+			if err != nil || !date.Before(before) {
+				continue
+			}
+		}
+		n, err := pruneCacheFiles(filepath.Join(cacheDir, namespace), func(os.FileInfo) bool { return true })
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// 删除修改时间早于 age 之前的缓存文件
+func PruneCacheOlderThan(cacheDir string, age time.Duration) (removed int, err error) {
+	namespaces, err := cacheNamespaces(cacheDir)
+	if err != nil {
+		return 0, err
+	}
+	deadline := time.Now().Add(-age)
+	for _, namespace := range namespaces {
+		n, err := pruneCacheFiles(filepath.Join(cacheDir, namespace), func(info os.FileInfo) bool {
+			return info.ModTime().Before(deadline)
+		})
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// 删除目录中满足条件的缓存文件，并同步更新链接列表
+func pruneCacheFiles(dir string, expired func(os.FileInfo) bool) (removed int, err error) {
+	files, err := filepath.Glob(filepath.Join(dir, "[0-9a-f][0-9a-f]", "*"))
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		info, statErr := os.Stat(file)
+		if statErr != nil || info.IsDir() || !expired(info) {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return removed, err
+		}
+		removed++
+		// 分目录删空后一并删除
+		os.Remove(filepath.Dir(file))
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	urls := readCacheManifest(dir)
+	kept := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if _, statErr := os.Stat(cacheFilePath(dir, u)); statErr == nil {
+			kept = append(kept, u)
+		}
+	}
+	cacheManifests.Lock()
+	delete(cacheManifests.known, dir)
+	cacheManifests.Unlock()
+	manifest := filepath.Join(dir, cacheManifestName)
+	if len(kept) == 0 {
+		os.Remove(manifest)
+		// 版本目录删空后一并删除
+		os.Remove(dir)
+		return removed, nil
+	}
+	return removed, ioutil.WriteFile(manifest, []byte(strings.Join(kept, "\n")+"\n"), 0644)
+}
+
+// 打印缓存列表
+func PrintCacheEntries(cacheDir string) error {
+	entries, err := ListCacheEntries(cacheDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		u := entry.Url
+		if u == "" {
+			u = "(未记录链接) " + entry.Path
+		}
+		namespace := entry.Namespace
+		if namespace == "" {
+			namespace = "-"
+		}
+		fmt.Printf("%s\t%s\t%d\t%s\n", namespace, entry.ModTime.Format("2006-01-02 15:04:05"), entry.Size, u)
+	}
+	return nil
+}
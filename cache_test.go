@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// 在缓存目录中写入一个页面并记录到链接列表
+func writeCacheEntry(t *testing.T, dir string, url string) {
+	t.Helper()
+	file := cacheFilePath(dir, url)
+	if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte("page"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	recordCachedURL(dir, url)
+}
+
+func TestPruneCacheBefore(t *testing.T) {
+	tests := []struct {
+		release     string
+		wantRemoved int
+		// 剩余的缓存所在的子目录
+		wantKept []string
+		wantErr  string
+	}{
+		{release: "2020-11-06", wantRemoved: 2, wantKept: []string{"2020-11-06", "2021-10-31", "other", indexCacheNamespace}},
+		{release: "2021-12-31", wantRemoved: 4, wantKept: []string{"other", indexCacheNamespace}},
+		{release: "2019-01-01", wantRemoved: 1, wantKept: []string{"2020-02-25", "2020-11-06", "2021-10-31", "other", indexCacheNamespace}},
+		// 格式错误的日期不删除任何缓存，根目录下的旧缓存也保留
+		{release: "2020", wantErr: "格式错误", wantKept: []string{"", "2020-02-25", "2020-11-06", "2021-10-31", "other", indexCacheNamespace}},
+		{release: "", wantErr: "格式错误", wantKept: []string{"", "2020-02-25", "2020-11-06", "2021-10-31", "other", indexCacheNamespace}},
+		{release: "2020-13-01", wantErr: "格式错误", wantKept: []string{"", "2020-02-25", "2020-11-06", "2021-10-31", "other", indexCacheNamespace}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.release, func(t *testing.T) {
+			cacheDir := testTempDir(t)
+			// 根目录下为旧版本的缓存
+			for _, namespace := range []string{"", "2020-02-25", "2020-11-06", "2021-10-31", "other", indexCacheNamespace} {
+				writeCacheEntry(t, filepath.Join(cacheDir, namespace), "http://example.com/"+namespace)
+			}
+			removed, err := PruneCacheBefore(cacheDir, tt.release)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("PruneCacheBefore(%q) error = %v, want %q", tt.release, err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("PruneCacheBefore(%q) error = %v", tt.release, err)
+			}
+			if removed != tt.wantRemoved {
+				t.Errorf("PruneCacheBefore(%q) removed %d, want %d", tt.release, removed, tt.wantRemoved)
+			}
+			entries, err := ListCacheEntries(cacheDir)
+			if err != nil {
+				t.Fatalf("ListCacheEntries() error = %v", err)
+			}
+			kept := make([]string, 0, len(entries))
+			for _, entry := range entries {
+				kept = append(kept, entry.Namespace)
+			}
+			sort.Strings(kept)
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Errorf("kept %q, want %q", kept, tt.wantKept)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"China_area_data/clog"
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// 断点文件中的一行，第一行只记录发布记录链接，之后每行记录一个已完整解析的页面
+type checkpointLine struct {
+	// 本次抓取对应的发布记录链接，与当前抓取的链接不同时断点作废
+	PrefixUrl string `json:"prefix_url,omitempty"`
+	// 已完整解析的页面链接
+	Link string `json:"link,omitempty"`
+	// 该页面解析出的下级数据
+	Children []checkpointNode `json:"children,omitempty"`
+	// 城市页面中是乡镇表而不是区县表
+	TownAsCounty bool `json:"town_as_county,omitempty"`
+}
+
+// 断点中保存的下级数据，需要保留链接以便继续抓取更下一级
+type checkpointNode struct {
+	Code     int    `json:"code"`
+	FullCode string `json:"full_code"`
+	Name     string `json:"name"`
+	Link     string `json:"link"`
+	// 仅村级数据有城乡分类代码
+	UrbanRuralCode string `json:"urban_rural_code,omitempty"`
+}
+
+// 抓取断点，记录已经完整解析的省份、城市、区县和乡镇页面，重新运行时跳过这些页面
+type crawlCheckpoint struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	done map[string]checkpointLine
+}
+
+// 打开断点文件并读取已完成的页面，断点对应的发布记录与 prefixUrl 不同时重新开始记录
+func openCheckpoint(path string, prefixUrl string) (*crawlCheckpoint, error) {
+	cp := &crawlCheckpoint{
+		path: path,
+		done: make(map[string]checkpointLine),
+	}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		valid := false
+		for scanner.Scan() {
+			var line checkpointLine
+			// 上次中断时最后一行可能没有写完整，直接忽略
+			if json.Unmarshal(scanner.Bytes(), &line) != nil {
+				continue
+			}
+			if line.PrefixUrl != "" {
+				valid = line.PrefixUrl == prefixUrl
+				continue
+			}
+			if valid && line.Link != "" {
+				cp.done[line.Link] = line
+			}
+		}
+		f.Close()
+		if len(cp.done) > 0 {
+			clog.Logger.Info("resume from checkpoint %s, %d pages already done", path, len(cp.done))
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	cp.file = file
+	// 重新写入已完成的页面，丢弃作废或不完整的记录
+	if err := cp.write(checkpointLine{PrefixUrl: prefixUrl}); err != nil {
+		file.Close()
+		return nil, err
+	}
+	for _, line := range cp.done {
+		if err := cp.write(line); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return cp, nil
+}
+
+func (cp *crawlCheckpoint) write(line checkpointLine) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	_, err = cp.file.Write(append(data, '\n'))
+	return err
+}
+
+// 查询页面是否已经完整解析过
+func (cp *crawlCheckpoint) lookup(link string) (checkpointLine, bool) {
+	if cp == nil {
+		return checkpointLine{}, false
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	line, ok := cp.done[link]
+	return line, ok
+}
+
+// 记录一个已经完整解析的页面
+func (cp *crawlCheckpoint) record(line checkpointLine) {
+	if cp == nil {
+		return
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.done[line.Link] = line
+	if err := cp.write(line); err != nil {
+		clog.Logger.Error("write checkpoint %s error: %v", cp.path, err)
+	}
+}
+
+// 关闭断点文件，completed 为 true 表示整棵树已抓取完成，此时删除断点文件
+func (cp *crawlCheckpoint) close(completed bool) {
+	if cp == nil {
+		return
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.file.Close()
+	if completed {
+		os.Remove(cp.path)
+	}
+}
+
+func citiesToNodes(cities []City) []checkpointNode {
+	nodes := make([]checkpointNode, 0, len(cities))
+	for _, c := range cities {
+		nodes = append(nodes, checkpointNode{Code: c.Code, FullCode: c.FullCode, Name: c.Name, Link: c.Link})
+	}
+	return nodes
+}
+
+func nodesToCities(nodes []checkpointNode) []City {
+	cities := make([]City, 0, len(nodes))
+	for _, n := range nodes {
+		cities = append(cities, City{Code: n.Code, FullCode: n.FullCode, Name: n.Name, Link: n.Link})
+	}
+	return cities
+}
+
+func countiesToNodes(counties []County) []checkpointNode {
+	nodes := make([]checkpointNode, 0, len(counties))
+	for _, c := range counties {
+		nodes = append(nodes, checkpointNode{Code: c.Code, FullCode: c.FullCode, Name: c.Name, Link: c.Link})
+	}
+	return nodes
+}
+
+func nodesToCounties(nodes []checkpointNode) []County {
+	counties := make([]County, 0, len(nodes))
+	for _, n := range nodes {
+		counties = append(counties, County{Code: n.Code, FullCode: n.FullCode, Name: n.Name, Link: n.Link})
+	}
+	return counties
+}
+
+func townsToNodes(towns []Town) []checkpointNode {
+	nodes := make([]checkpointNode, 0, len(towns))
+	for _, t := range towns {
+		nodes = append(nodes, checkpointNode{Code: t.Code, FullCode: t.FullCode, Name: t.Name, Link: t.Link})
+	}
+	return nodes
+}
+
+func nodesToTowns(nodes []checkpointNode) []Town {
+	towns := make([]Town, 0, len(nodes))
+	for _, n := range nodes {
+		towns = append(towns, Town{Code: n.Code, FullCode: n.FullCode, Name: n.Name, Link: n.Link})
+	}
+	return towns
+}
+
+func villagesToNodes(villages []Village) []checkpointNode {
+	nodes := make([]checkpointNode, 0, len(villages))
+	for _, v := range villages {
+		nodes = append(nodes, checkpointNode{Code: v.Code, FullCode: v.FullCode, Name: v.Name, UrbanRuralCode: v.UrbanRuralCode})
+	}
+	return nodes
+}
+
+func nodesToVillages(nodes []checkpointNode) []Village {
+	villages := make([]Village, 0, len(nodes))
+	for _, n := range nodes {
+		villages = append(villages, Village{Code: n.Code, FullCode: n.FullCode, Name: n.Name, UrbanRuralCode: n.UrbanRuralCode})
+	}
+	return villages
+}
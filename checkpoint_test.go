@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCrawlResumesFromCheckpoint(t *testing.T) {
+	site := newStatsSite(t)
+	prefixUrl := site.URL + "/2020/"
+	want, err := newTestSession(t, site, nil).GetProvinceUrlAndData(context.Background(), prefixUrl)
+	if err != nil {
+		t.Fatalf("GetProvinceUrlAndData() error = %v", err)
+	}
+	site.takeRequests()
+
+	checkpointFile := filepath.Join(testTempDir(t), "抓取断点")
+	session := newTestSession(t, site, func(options *CrawlOptions) {
+		// 串行抓取，中断时东莞市之前的页面都已完成
+		options.Parallelism = 1
+		options.CheckpointFile = checkpointFile
+	})
+	session.setCacheNamespace("2020-11-06")
+
+	// 抓取到东莞市时中断
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	site.override("/2020/44/4419.html", func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	_, err = session.GetProvinceUrlAndData(ctx, prefixUrl)
+	var partialErr *PartialCrawlError
+	if !errors.As(err, &partialErr) || partialErr.Cause != context.Canceled {
+		t.Fatalf("interrupted GetProvinceUrlAndData() error = %v, want canceled", err)
+	}
+	path := checkpointFile + "_2020-11-06"
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("checkpoint %s: %v", path, err)
+	}
+
+	// 继续抓取时只请求发布记录页面和没有完成的东莞市页面
+	site.override("/2020/44/4419.html", nil)
+	site.takeRequests()
+	got, err := session.GetProvinceUrlAndData(context.Background(), prefixUrl)
+	if err != nil {
+		t.Fatalf("resumed GetProvinceUrlAndData() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed GetProvinceUrlAndData() =\n%s\nwant\n%s", strings.Join(areaOutline(got), "\n"), strings.Join(areaOutline(want), "\n"))
+	}
+	if requests, wantRequests := site.takeRequests(), []string{"/2020/", "/2020/44/4419.html"}; !reflect.DeepEqual(requests, wantRequests) {
+		t.Errorf("resumed crawl requested %v, want %v", requests, wantRequests)
+	}
+	// 抓取完成后删除断点文件
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("checkpoint %s not removed: %v", path, err)
+	}
+}
+
+// 断点对应的发布记录链接不同时作废，重新抓取全部页面
+func TestCheckpointOtherRelease(t *testing.T) {
+	path := filepath.Join(testTempDir(t), "抓取断点")
+	cp, err := openCheckpoint(path, "http://example.com/2019/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.record(checkpointLine{Link: "http://example.com/2019/37.html", Children: citiesToNodes([]City{{Code: 3701, FullCode: "370100000000", Name: "济南市", Link: "http://example.com/2019/37/3701.html"}})})
+	cp.close(false)
+
+	cp, err = openCheckpoint(path, "http://example.com/2019/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, ok := cp.lookup("http://example.com/2019/37.html")
+	if !ok || len(line.Children) != 1 || nodesToCities(line.Children)[0].Link != "http://example.com/2019/37/3701.html" {
+		t.Errorf("lookup() = %+v, %v", line, ok)
+	}
+	cp.close(false)
+
+	cp, err = openCheckpoint(path, "http://example.com/2020/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.close(true)
+	if _, ok := cp.lookup("http://example.com/2019/37.html"); ok {
+		t.Error("lookup() found a page of another release")
+	}
+}
@@ -0,0 +1,142 @@
+package clog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 日志级别
+const (
+	LevelDebug = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// 附加在日志中的结构化字段，如 url、level、attempt
+type Fields map[string]interface{}
+
+// 日志接口，可替换为 zap、logrus 等日志库的适配
+type Interface interface {
+	Debug(format string, args ...interface{})
+	Info(format string, args ...interface{})
+	Warn(format string, args ...interface{})
+	Error(format string, args ...interface{})
+	// 返回附带字段的日志，原日志不受影响
+	With(fields Fields) Interface
+}
+
+// 全局日志，默认以文本格式输出 info 及以上级别到标准错误，需在使用前替换
+var Logger Interface = New(os.Stderr, LevelInfo, false)
+
+// 解析日志级别名称 debug、info、warn、error
+func ParseLevel(name string) (int, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("未知的日志级别 %s", name)
+}
+
+// 输出到 out 的日志，低于 level 的日志丢弃，jsonFormat 为 true 时每行输出一个 JSON 对象，便于日志平台采集
+func New(out io.Writer, level int, jsonFormat bool) Interface {
+	return &logger{
+		mu:         &sync.Mutex{},
+		out:        out,
+		level:      level,
+		jsonFormat: jsonFormat,
+	}
+}
+
+type logger struct {
+	// With 返回的日志共用同一个锁，保证同一输出中的行不交错
+	mu         *sync.Mutex
+	out        io.Writer
+	level      int
+	jsonFormat bool
+	fields     Fields
+}
+
+func (l *logger) Debug(format string, args ...interface{}) {
+	l.output(LevelDebug, format, args)
+}
+
+func (l *logger) Info(format string, args ...interface{}) {
+	l.output(LevelInfo, format, args)
+}
+
+func (l *logger) Warn(format string, args ...interface{}) {
+	l.output(LevelWarn, format, args)
+}
+
+func (l *logger) Error(format string, args ...interface{}) {
+	l.output(LevelError, format, args)
+}
+
+func (l *logger) With(fields Fields) Interface {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	child := *l
+	child.fields = merged
+	return &child
+}
+
+func (l *logger) output(level int, format string, args []interface{}) {
+	if level < l.level {
+		return
+	}
+	now := time.Now()
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	var line []byte
+	if l.jsonFormat {
+		entry := make(map[string]interface{}, len(l.fields)+3)
+		for k, v := range l.fields {
+			// error 类型直接序列化为空对象，转为字符串
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			entry[k] = v
+		}
+		entry["time"] = now.Format(time.RFC3339Nano)
+		entry["level"] = levelNames[level]
+		entry["msg"] = msg
+		data, err := json.Marshal(entry)
+		if err != nil {
+			data, _ = json.Marshal(map[string]string{"time": entry["time"].(string), "level": levelNames[level], "msg": msg})
+		}
+		line = append(data, '\n')
+	} else {
+		var b strings.Builder
+		b.WriteString(now.Format("2006/01/02 15:04:05 "))
+		b.WriteString(strings.ToUpper(levelNames[level]))
+		b.WriteString(" ")
+		b.WriteString(msg)
+		keys := make([]string, 0, len(l.fields))
+		for k := range l.fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, " %s=%v", k, l.fields[k])
+		}
+		b.WriteString("\n")
+		line = []byte(b.String())
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 统计用区划代码固定为12位：省2位、市2位、区县2位、乡镇3位、村3位
+const fullCodeLength = 12
+
+// 各层级短代码在12位代码中的前缀长度
+var levelCodeLength = map[int]int{
+	LevelProvince: 2,
+	LevelCity:     4,
+	LevelCounty:   6,
+	LevelTown:     9,
+	LevelVillage:  12,
+}
+
+// 从表格行文本中截取开头的12位统计用区划代码
+func parseFullCode(text string) (string, error) {
+	if len(text) < fullCodeLength {
+		return "", fmt.Errorf("区划代码长度不足12位: %q", text)
+	}
+	code := text[:fullCodeLength]
+	if _, err := strconv.ParseUint(code, 10, 64); err != nil {
+		return "", fmt.Errorf("区划代码不是数字: %q", code)
+	}
+	return code, nil
+}
+
+// 将不足12位的代码(如省份链接中的 11)右侧补零为12位
+func padFullCode(code string) string {
+	if len(code) >= fullCodeLength {
+		return code[:fullCodeLength]
+	}
+	return code + strings.Repeat("0", fullCodeLength-len(code))
+}
+
+// 由12位区划代码得到指定层级的短代码，如 110101001000 在区县级为 110101
+func shortCode(fullCode string, level int) (int, error) {
+	length, ok := levelCodeLength[level]
+	if !ok {
+		return 0, fmt.Errorf("未知的层级: %d", level)
+	}
+	if len(fullCode) != fullCodeLength {
+		return 0, fmt.Errorf("区划代码不是12位: %q", fullCode)
+	}
+	return strconv.Atoi(fullCode[:length])
+}
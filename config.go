@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// 抓取目标网站的配置，网站改版时修改配置文件即可，不需要修改代码
+type CrawlerConfig struct {
+	// 发布记录列表页面
+	BaseUrl string `json:"base_url"`
+	// 页面编码，如 gbk、utf-8，为空时根据页面自动检测
+	Charset   string           `json:"charset"`
+	Selectors CrawlerSelectors `json:"selectors"`
+}
+
+// 各层级页面的 CSS 选择器
+type CrawlerSelectors struct {
+	Release  ReleaseSelectors `json:"release"`
+	Province TableSelectors   `json:"province"`
+	City     TableSelectors   `json:"city"`
+	County   TableSelectors   `json:"county"`
+	Town     TableSelectors   `json:"town"`
+	Village  TableSelectors   `json:"village"`
+}
+
+// 发布记录列表页面的选择器
+type ReleaseSelectors struct {
+	// 发布记录列表
+	List string `json:"list"`
+	// 列表中的每条记录，为带链接的 a 标签
+	Item string `json:"item"`
+	// 记录中的发布日期
+	Date string `json:"date"`
+	// 记录中的版本年份，取不到时使用链接中的年份目录
+	Year string `json:"year"`
+}
+
+// 区划表格的选择器，每一行的文本依次为12位区划代码和名称(村级在中间多3位城乡分类代码)
+type TableSelectors struct {
+	// 区划表格
+	Table string `json:"table"`
+	// 表格中的每一行
+	Row string `json:"row"`
+	// 行中指向下级页面的链接
+	Link string `json:"link"`
+}
+
+// 默认配置，对应国家统计局网站当前的页面结构
+var DefaultCrawlerConfig = CrawlerConfig{
+	BaseUrl: "http://www.stats.gov.cn/tjsj/tjbz/tjyqhdmhcxhfdm/",
+	Selectors: CrawlerSelectors{
+		Release: ReleaseSelectors{
+			List: "div[class='center'] div[class='center_list'] ul[class='center_list_contlist']",
+			Item: "ul li a",
+			Date: "span font[class='cont_tit02']",
+			Year: "span font[class='cont_tit03']",
+		},
+		Province: TableSelectors{
+			Table: "tr[class='provincetr']",
+			Row:   "tr[class='provincetr'] > td",
+			Link:  "a",
+		},
+		City: TableSelectors{
+			Table: ".citytable tbody",
+			Row:   "tr[class='citytr']",
+			Link:  "td > a",
+		},
+		County: TableSelectors{
+			Table: ".countytable tbody",
+			Row:   "tr[class='countytr']",
+			Link:  "a",
+		},
+		Town: TableSelectors{
+			Table: ".towntable tbody",
+			Row:   "tr[class='towntr']",
+			Link:  "a",
+		},
+		Village: TableSelectors{
+			Table: ".villagetable tbody",
+			Row:   "tr[class='villagetr']",
+		},
+	},
+}
+
+// 读取 JSON 格式的配置文件，文件中没有填写的项沿用默认配置
+func LoadCrawlerConfig(fileName string) (CrawlerConfig, error) {
+	config := DefaultCrawlerConfig
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("parse %s error:%v", fileName, err)
+	}
+	if err := config.validate(); err != nil {
+		return config, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return config, nil
+}
+
+func (config CrawlerConfig) validate() error {
+	if config.BaseUrl == "" {
+		return errors.New("base_url 不能为空")
+	}
+	s := config.Selectors
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"selectors.release.list", s.Release.List},
+		{"selectors.release.item", s.Release.Item},
+		{"selectors.release.date", s.Release.Date},
+		{"selectors.province.table", s.Province.Table},
+		{"selectors.province.row", s.Province.Row},
+		{"selectors.city.table", s.City.Table},
+		{"selectors.city.row", s.City.Row},
+		{"selectors.county.table", s.County.Table},
+		{"selectors.county.row", s.County.Row},
+		{"selectors.town.table", s.Town.Table},
+		{"selectors.town.row", s.Town.Row},
+		{"selectors.village.table", s.Village.Table},
+		{"selectors.village.row", s.Village.Row},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			return fmt.Errorf("%s 不能为空", r.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"China_area_data/clog"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 抓取层级
+const (
+	LevelProvince = iota + 1
+	LevelCity
+	LevelCounty
+	LevelTown
+	// 村级页面数量达数十万，需要显式开启
+	LevelVillage
+)
+
+// 按名称解析抓取层级，可选 county、town、village
+func ParseCrawlLevel(name string) (int, error) {
+	switch name {
+	case "county":
+		return LevelCounty, nil
+	case "town":
+		return LevelTown, nil
+	case "village":
+		return LevelVillage, nil
+	}
+	return 0, fmt.Errorf("unknown crawl level %q, want county, town or village", name)
+}
+
+// 爬虫并发配置
+type CrawlOptions struct {
+	// 抓取到的最深层级，至少抓取到区县级
+	MaxLevel int
+	// 同时抓取的最大页面数
+	Parallelism int
+	// 同一域名相邻两次请求之间的固定间隔
+	Delay time.Duration
+	// 在 Delay 之外追加的随机间隔上限
+	RandomDelay time.Duration
+	// 单个页面的最大尝试次数（含第一次）
+	MaxAttempts int
+	// 第一次重试前的等待时间，之后每次翻倍
+	RetryBaseDelay time.Duration
+	// 单次重试等待时间上限
+	RetryMaxDelay time.Duration
+	// 断点文件路径，实际文件名后加上发布日期，如 抓取断点_2020-11-06，为空时不记录断点
+	CheckpointFile string
+	// 离线模式，所有页面只从缓存目录读取，缓存中没有的页面直接失败且不重试
+	Offline bool
+	// 缓存根目录，各版本的页面缓存在以发布日期命名的子目录中，为空时不缓存
+	CacheDir string
+}
+
+// 默认并发配置，需在创建 CrawlerSession 前修改才会生效
+var DefaultCrawlOptions = CrawlOptions{
+	MaxLevel:       LevelCounty,
+	Parallelism:    8,
+	Delay:          50 * time.Millisecond,
+	RandomDelay:    200 * time.Millisecond,
+	MaxAttempts:    4,
+	RetryBaseDelay: time.Second,
+	RetryMaxDelay:  30 * time.Second,
+	CheckpointFile: "抓取断点",
+	CacheDir:       "./缓存",
+}
+
+// 单个页面多次重试后仍然失败的记录
+type CrawlFailure struct {
+	// 失败页面的层级，province 为省份页面(市级列表)，city 为城市页面(区县列表)，county 为区县页面(乡镇列表)，town 为乡镇页面(村级列表)
+	Level string `json:"level"`
+	// 省份或城市名称
+	Name string `json:"name"`
+	// 页面链接
+	Url string `json:"url"`
+	// 尝试次数
+	Attempts int `json:"attempts"`
+	// 最后一次的错误信息
+	Error string `json:"error"`
+}
+
+// 部分页面抓取失败，其余数据仍然有效
+type PartialCrawlError struct {
+	Failures []CrawlFailure
+	// 抓取被取消或超时时为 ctx.Err()，此时剩余的页面没有抓取
+	Cause error
+}
+
+func (e *PartialCrawlError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("抓取中断: %v，%d 个页面抓取失败", e.Cause, len(e.Failures))
+	}
+	return fmt.Sprintf("%d 个页面抓取失败", len(e.Failures))
+}
+
+func (e *PartialCrawlError) Unwrap() error {
+	return e.Cause
+}
+
+// 并发收集抓取失败的页面
+type crawlFailures struct {
+	mu       sync.Mutex
+	failures []CrawlFailure
+}
+
+func (f *crawlFailures) add(level, name, url string, attempts int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, CrawlFailure{
+		Level:    level,
+		Name:     name,
+		Url:      url,
+		Attempts: attempts,
+		Error:    err.Error(),
+	})
+}
+
+// 按链接排序返回，保证每次输出的顺序一致
+func (f *crawlFailures) list() []CrawlFailure {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	failures := append([]CrawlFailure(nil), f.failures...)
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Url < failures[j].Url
+	})
+	return failures
+}
+
+// 抓取 url 对应的页面，失败后按指数退避加随机抖动重试，返回实际尝试次数和最后一次的错误
+// ctx 结束后不再重试，返回 ctx.Err()
+func (s *CrawlerSession) retry(ctx context.Context, url string, fetch func() error) (attempts int, err error) {
+	maxAttempts := s.options.MaxAttempts
+	// 离线模式下结果是确定的，重试没有意义
+	if maxAttempts < 1 || s.options.Offline {
+		maxAttempts = 1
+	}
+	for attempts = 1; ; attempts++ {
+		err = fetch()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return attempts, ctxErr
+		}
+		if err == nil || attempts >= maxAttempts {
+			return
+		}
+		delay := s.backoff(attempts)
+		clog.Logger.With(clog.Fields{"url": url, "attempt": attempts, "max_attempts": maxAttempts}).Warn("visit error: %v, retry after %v", err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return attempts, ctx.Err()
+		}
+	}
+}
+
+// 第 attempt 次失败后的等待时间，在 [d/2, d] 之间随机，d 为按次数翻倍后的退避时间
+// RetryBaseDelay 不大于 0 时不等待，翻倍溢出时按 RetryMaxDelay 计算
+func (s *CrawlerSession) backoff(attempt int) time.Duration {
+	if s.options.RetryBaseDelay <= 0 {
+		return 0
+	}
+	d := s.options.RetryBaseDelay << uint(attempt-1)
+	if max := s.options.RetryMaxDelay; max > 0 && (d > max || d <= 0) {
+		d = max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// 以不超过 parallelism 的并发执行 n 个任务，任务i的结果由调用方按下标写回，保证顺序不变
+// 出现错误后不再启动新任务，返回第一个错误
+func runParallel(n int, parallelism int, task func(i int) error) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, parallelism)
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := task(i); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// 离线模式的 http 传输层，缓存未命中的请求直接失败
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("离线模式下缓存中没有 %s", req.URL)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunParallelOrder(t *testing.T) {
+	for _, parallelism := range []int{0, 1, 4, 100} {
+		var running, maxRunning int32
+		results := make([]int, 50)
+		err := runParallel(len(results), parallelism, func(i int) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+			// 后启动的任务先完成，结果仍按下标写回
+			time.Sleep(time.Duration(len(results)-i) * 100 * time.Microsecond)
+			results[i] = i * i
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("runParallel(%d) error = %v", parallelism, err)
+		}
+		for i, got := range results {
+			if got != i*i {
+				t.Fatalf("runParallel(%d) results[%d] = %d, want %d", parallelism, i, got, i*i)
+			}
+		}
+		want := int32(parallelism)
+		if want < 1 {
+			want = 1
+		}
+		if maxRunning > want {
+			t.Errorf("runParallel(%d) ran %d tasks at once", parallelism, maxRunning)
+		}
+	}
+}
+
+func TestRunParallelStopsOnError(t *testing.T) {
+	errTask := errors.New("task 3 failed")
+	var started int32
+	err := runParallel(10, 1, func(i int) error {
+		atomic.AddInt32(&started, 1)
+		if i == 3 {
+			return errTask
+		}
+		return nil
+	})
+	if err != errTask {
+		t.Errorf("runParallel() error = %v, want %v", err, errTask)
+	}
+	// 串行执行时出错后不再启动新任务
+	if started != 4 {
+		t.Errorf("started %d tasks, want 4", started)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	errFetch := errors.New("503 Service Unavailable")
+	tests := []struct {
+		name        string
+		maxAttempts int
+		offline     bool
+		// 前几次请求失败
+		failures     int
+		wantAttempts int
+		wantErr      error
+	}{
+		{"success", 4, false, 0, 1, nil},
+		{"success after retries", 4, false, 2, 3, nil},
+		{"success on last attempt", 4, false, 3, 4, nil},
+		{"give up", 4, false, 10, 4, errFetch},
+		{"no retry", 1, false, 10, 1, errFetch},
+		{"max attempts not set", 0, false, 10, 1, errFetch},
+		// 离线模式下不重试
+		{"offline", 4, true, 10, 1, errFetch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &CrawlerSession{options: CrawlOptions{MaxAttempts: tt.maxAttempts, Offline: tt.offline, RetryBaseDelay: time.Millisecond}}
+			calls := 0
+			attempts, err := s.retry(context.Background(), "http://example.com/37.html", func() error {
+				calls++
+				if calls <= tt.failures {
+					return errFetch
+				}
+				return nil
+			})
+			if attempts != tt.wantAttempts || calls != tt.wantAttempts || err != tt.wantErr {
+				t.Errorf("retry() = %d, %v (fetched %d times), want %d, %v", attempts, err, calls, tt.wantAttempts, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryStopsOnCancel(t *testing.T) {
+	s := &CrawlerSession{options: CrawlOptions{MaxAttempts: 10, RetryBaseDelay: time.Hour}}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	attempts, err := s.retry(ctx, "http://example.com/37.html", func() error {
+		return errors.New("503 Service Unavailable")
+	})
+	// 等待重试期间取消，不再发起请求
+	if attempts != 1 || err != context.Canceled {
+		t.Errorf("retry() = %d, %v, want 1, %v", attempts, err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("retry() returned after %v", elapsed)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		base, max time.Duration
+		attempt   int
+		min, want time.Duration
+	}{
+		{100 * time.Millisecond, time.Second, 1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{100 * time.Millisecond, time.Second, 2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{100 * time.Millisecond, time.Second, 3, 200 * time.Millisecond, 400 * time.Millisecond},
+		// 超过上限时按上限计算
+		{100 * time.Millisecond, time.Second, 5, 500 * time.Millisecond, time.Second},
+		{100 * time.Millisecond, time.Second, 70, 500 * time.Millisecond, time.Second},
+		// 没有上限
+		{100 * time.Millisecond, 0, 5, 800 * time.Millisecond, 1600 * time.Millisecond},
+		{0, time.Second, 3, 0, 0},
+	}
+	for _, tt := range tests {
+		s := &CrawlerSession{options: CrawlOptions{RetryBaseDelay: tt.base, RetryMaxDelay: tt.max}}
+		for i := 0; i < 20; i++ {
+			if got := s.backoff(tt.attempt); got < tt.min || got > tt.want {
+				t.Errorf("backoff(%d) with base %v, max %v = %v, want between %v and %v", tt.attempt, tt.base, tt.max, got, tt.min, tt.want)
+				break
+			}
+		}
+	}
+}
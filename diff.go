@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 区划变更类型
+const (
+	// 新增
+	ChangeAdded = "added"
+	// 撤销
+	ChangeAbolished = "abolished"
+	// 更名，代码不变
+	ChangeRenamed = "renamed"
+	// 变更隶属，划归其它上级，代码通常也随之改变
+	ChangeReparented = "reparented"
+	// 变更代码，名称和上级不变
+	ChangeRecoded = "recoded"
+)
+
+var changeTypeNames = map[string]string{
+	ChangeAdded:      "新增",
+	ChangeAbolished:  "撤销",
+	ChangeRenamed:    "更名",
+	ChangeReparented: "变更隶属",
+	ChangeRecoded:    "变更代码",
+}
+
+var levelNames = map[string]string{
+	"province": "省份",
+	"city":     "城市",
+	"county":   "区县",
+	"town":     "乡镇",
+}
+
+// 参与比较的层级，村级数据量过大不做比较
+var diffLevels = []string{"province", "city", "county", "town"}
+
+// 一处区划变更，代码均为12位统计用区划代码
+type AreaChange struct {
+	Type  string `json:"type"`
+	Level string `json:"level"`
+	// 变更前的代码、名称及上级，新增时为空
+	OldCode       string `json:"old_code,omitempty"`
+	OldName       string `json:"old_name,omitempty"`
+	OldParentCode string `json:"old_parent_code,omitempty"`
+	OldParentName string `json:"old_parent_name,omitempty"`
+	// 变更后的代码、名称及上级，撤销时为空
+	NewCode       string `json:"new_code,omitempty"`
+	NewName       string `json:"new_name,omitempty"`
+	NewParentCode string `json:"new_parent_code,omitempty"`
+	NewParentName string `json:"new_parent_name,omitempty"`
+	// 旧版本中同一层级有多个节点使用 OldCode，如旧数据文件中东莞市下属的镇，不能据此生成新旧代码对照
+	OldCodeDuplicated bool `json:"old_code_duplicated,omitempty"`
+}
+
+// 两个版本之间的全部区划变更
+type ReleaseDiff struct {
+	OldRelease string       `json:"old_release"`
+	NewRelease string       `json:"new_release"`
+	Changes    []AreaChange `json:"changes"`
+}
+
+// 参与比较的节点，ParentName 为上级的完整名称，如 山东省济南市
+type diffNode struct {
+	level      string
+	code       string
+	name       string
+	parentCode string
+	parentName string
+}
+
+// 比较两个版本的省市区数据，得到从 oldProvinces 到 newProvinces 的全部变更
+func DiffReleases(oldRelease string, oldProvinces []Province, newRelease string, newProvinces []Province) ReleaseDiff {
+	oldNodes := flattenDiffNodes(oldProvinces)
+	newNodes := flattenDiffNodes(newProvinces)
+	changes := make([]AreaChange, 0)
+	for _, level := range diffLevels {
+		changes = append(changes, diffLevel(oldNodes[level], newNodes[level])...)
+	}
+	return ReleaseDiff{
+		OldRelease: oldRelease,
+		NewRelease: newRelease,
+		Changes:    changes,
+	}
+}
+
+// 读取两个版本的数据文件并比较
+func DiffReleaseFiles(oldFile string, newFile string) (ReleaseDiff, error) {
+	oldProvinces, err := readProvincesFile(oldFile)
+	if err != nil {
+		return ReleaseDiff{}, err
+	}
+	newProvinces, err := readProvincesFile(newFile)
+	if err != nil {
+		return ReleaseDiff{}, err
+	}
+	return DiffReleases(oldFile, oldProvinces, newFile, newProvinces), nil
+}
+
+func readProvincesFile(fileName string) ([]Province, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	provinces := make([]Province, 0)
+	if err := json.Unmarshal(data, &provinces); err != nil {
+		return nil, fmt.Errorf("parse %s error:%v", fileName, err)
+	}
+	return provinces, nil
+}
+
+// 按层级展开为节点列表，旧版本数据文件中没有 full_code 时由短代码补齐
+func flattenDiffNodes(provinces []Province) map[string][]diffNode {
+	nodes := make(map[string][]diffNode)
+	for _, p := range provinces {
+		pCode := nodeFullCode(p.FullCode, p.Code)
+		nodes["province"] = append(nodes["province"], diffNode{level: "province", code: pCode, name: p.Name})
+		for _, city := range p.Cities {
+			cCode := nodeFullCode(city.FullCode, city.Code)
+			nodes["city"] = append(nodes["city"], diffNode{level: "city", code: cCode, name: city.Name, parentCode: pCode, parentName: p.Name})
+			for _, county := range city.Counties {
+				coCode := nodeFullCode(county.FullCode, county.Code)
+				coParent := p.Name + city.Name
+				nodes["county"] = append(nodes["county"], diffNode{level: "county", code: coCode, name: county.Name, parentCode: cCode, parentName: coParent})
+				for _, town := range county.Towns {
+					tCode := nodeFullCode(town.FullCode, town.Code)
+					nodes["town"] = append(nodes["town"], diffNode{level: "town", code: tCode, name: town.Name, parentCode: coCode, parentName: coParent + county.Name})
+				}
+			}
+		}
+	}
+	return nodes
+}
+
+func nodeFullCode(fullCode string, code int) string {
+	if fullCode != "" {
+		return fullCode
+	}
+	return padFullCode(strconv.Itoa(code))
+}
+
+// 比较同一层级的节点
+// 先按代码匹配得到更名和变更隶属(两者可能同时发生)，剩余节点再按名称匹配：上级相同为变更代码，上级不同且名称唯一为变更隶属
+// 仍未匹配的旧节点为撤销，新节点为新增
+// 旧版本数据中东莞市等城市下属的镇代码重复，重复的代码不参与代码匹配
+func diffLevel(oldNodes []diffNode, newNodes []diffNode) []AreaChange {
+	changes := make([]AreaChange, 0)
+	codeCount := make(map[string]int)
+	oldCodeCount := make(map[string]int, len(oldNodes))
+	for _, o := range oldNodes {
+		codeCount[o.code]++
+		oldCodeCount[o.code]++
+	}
+	newByCode := make(map[string]diffNode, len(newNodes))
+	for _, n := range newNodes {
+		newByCode[n.code] = n
+		codeCount[n.code]++
+	}
+	uniqueCode := func(code string) bool {
+		return codeCount[code] == 2
+	}
+	matchedOld := make(map[string]bool, len(oldNodes))
+	var oldRest []diffNode
+	for _, o := range oldNodes {
+		n, ok := newByCode[o.code]
+		if !ok || !uniqueCode(o.code) {
+			oldRest = append(oldRest, o)
+			continue
+		}
+		matchedOld[o.code] = true
+		// 同时变更隶属和更名时两项变更都记录
+		if o.parentCode != n.parentCode {
+			changes = append(changes, newAreaChange(ChangeReparented, &o, &n))
+		}
+		if o.name != n.name {
+			changes = append(changes, newAreaChange(ChangeRenamed, &o, &n))
+		}
+	}
+	var newRest []diffNode
+	for _, n := range newNodes {
+		if !matchedOld[n.code] {
+			newRest = append(newRest, n)
+		}
+	}
+
+	// 名称在剩余节点中出现的次数，只有唯一的名称才跨上级匹配
+	oldNameCount := make(map[string]int)
+	for _, o := range oldRest {
+		oldNameCount[o.name]++
+	}
+	newNameCount := make(map[string]int)
+	for _, n := range newRest {
+		newNameCount[n.name]++
+	}
+	matchedNew := make(map[int]bool)
+	for _, o := range oldRest {
+		match := -1
+		for i := range newRest {
+			n := &newRest[i]
+			if matchedNew[i] || n.name != o.name {
+				continue
+			}
+			if n.parentName == o.parentName {
+				match = i
+				break
+			}
+			if match < 0 && oldNameCount[o.name] == 1 && newNameCount[n.name] == 1 {
+				match = i
+			}
+		}
+		if match < 0 {
+			changes = append(changes, newAreaChange(ChangeAbolished, &o, nil))
+			continue
+		}
+		matchedNew[match] = true
+		n := &newRest[match]
+		if n.code == o.code && n.parentName == o.parentName {
+			continue
+		}
+		if n.parentName == o.parentName {
+			changes = append(changes, newAreaChange(ChangeRecoded, &o, n))
+		} else {
+			changes = append(changes, newAreaChange(ChangeReparented, &o, n))
+		}
+	}
+	for i := range newRest {
+		if !matchedNew[i] {
+			changes = append(changes, newAreaChange(ChangeAdded, nil, &newRest[i]))
+		}
+	}
+
+	for i := range changes {
+		changes[i].OldCodeDuplicated = oldCodeCount[changes[i].OldCode] > 1
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changeSortCode(changes[i]) < changeSortCode(changes[j])
+	})
+	return changes
+}
+
+func newAreaChange(changeType string, o *diffNode, n *diffNode) AreaChange {
+	change := AreaChange{Type: changeType}
+	if o != nil {
+		change.Level = o.level
+		change.OldCode = o.code
+		change.OldName = o.name
+		change.OldParentCode = o.parentCode
+		change.OldParentName = o.parentName
+	}
+	if n != nil {
+		change.Level = n.level
+		change.NewCode = n.code
+		change.NewName = n.name
+		change.NewParentCode = n.parentCode
+		change.NewParentName = n.parentName
+	}
+	return change
+}
+
+// 按旧代码排序，新增的按新代码排序
+func changeSortCode(change AreaChange) string {
+	if change.OldCode != "" {
+		return change.OldCode
+	}
+	return change.NewCode
+}
+
+// 各类变更的数量
+func (d ReleaseDiff) Counts() map[string]int {
+	counts := make(map[string]int)
+	for _, change := range d.Changes {
+		counts[change.Type]++
+	}
+	return counts
+}
+
+// 中文变更说明
+func (d ReleaseDiff) Summary() string {
+	var b strings.Builder
+	counts := d.Counts()
+	fmt.Fprintf(&b, "%s → %s 共 %d 处变更：新增 %d 个，撤销 %d 个，更名 %d 个，变更隶属 %d 个，变更代码 %d 个\n",
+		d.OldRelease, d.NewRelease, len(d.Changes),
+		counts[ChangeAdded], counts[ChangeAbolished], counts[ChangeRenamed], counts[ChangeReparented], counts[ChangeRecoded])
+	for _, change := range d.Changes {
+		typeName := changeTypeNames[change.Type]
+		levelName := levelNames[change.Level]
+		switch change.Type {
+		case ChangeAdded:
+			fmt.Fprintf(&b, "%s%s %s%s(%s)\n", typeName, levelName, change.NewParentName, change.NewName, change.NewCode)
+		case ChangeAbolished:
+			fmt.Fprintf(&b, "%s%s %s%s(%s)\n", typeName, levelName, change.OldParentName, change.OldName, change.OldCode)
+		case ChangeRenamed:
+			fmt.Fprintf(&b, "%s%s %s(%s)：%s → %s\n", typeName, levelName, change.OldParentName, change.OldCode, change.OldName, change.NewName)
+		default:
+			fmt.Fprintf(&b, "%s%s %s%s(%s) → %s%s(%s)\n", typeName, levelName,
+				change.OldParentName, change.OldName, change.OldCode,
+				change.NewParentName, change.NewName, change.NewCode)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffLevel(t *testing.T) {
+	jinan := diffNode{level: "county", code: "370102000000", name: "历下区", parentCode: "370100000000", parentName: "山东省济南市"}
+	tests := []struct {
+		name     string
+		oldNodes []diffNode
+		newNodes []diffNode
+		want     []AreaChange
+	}{
+		{
+			name:     "unchanged",
+			oldNodes: []diffNode{jinan},
+			newNodes: []diffNode{jinan},
+			want:     []AreaChange{},
+		},
+		{
+			name:     "added",
+			newNodes: []diffNode{jinan},
+			want: []AreaChange{{
+				Type: ChangeAdded, Level: "county",
+				NewCode: "370102000000", NewName: "历下区", NewParentCode: "370100000000", NewParentName: "山东省济南市",
+			}},
+		},
+		{
+			name:     "abolished",
+			oldNodes: []diffNode{jinan},
+			want: []AreaChange{{
+				Type: ChangeAbolished, Level: "county",
+				OldCode: "370102000000", OldName: "历下区", OldParentCode: "370100000000", OldParentName: "山东省济南市",
+			}},
+		},
+		{
+			name:     "renamed",
+			oldNodes: []diffNode{{level: "county", code: "370181000000", name: "章丘市", parentCode: "370100000000", parentName: "山东省济南市"}},
+			newNodes: []diffNode{{level: "county", code: "370181000000", name: "章丘区", parentCode: "370100000000", parentName: "山东省济南市"}},
+			want: []AreaChange{{
+				Type: ChangeRenamed, Level: "county",
+				OldCode: "370181000000", OldName: "章丘市", OldParentCode: "370100000000", OldParentName: "山东省济南市",
+				NewCode: "370181000000", NewName: "章丘区", NewParentCode: "370100000000", NewParentName: "山东省济南市",
+			}},
+		},
+		{
+			name:     "reparented",
+			oldNodes: []diffNode{{level: "county", code: "371202000000", name: "莱城区", parentCode: "371200000000", parentName: "山东省莱芜市"}},
+			newNodes: []diffNode{{level: "county", code: "370116000000", name: "莱城区", parentCode: "370100000000", parentName: "山东省济南市"}},
+			want: []AreaChange{{
+				Type: ChangeReparented, Level: "county",
+				OldCode: "371202000000", OldName: "莱城区", OldParentCode: "371200000000", OldParentName: "山东省莱芜市",
+				NewCode: "370116000000", NewName: "莱城区", NewParentCode: "370100000000", NewParentName: "山东省济南市",
+			}},
+		},
+		{
+			name:     "recoded",
+			oldNodes: []diffNode{{level: "county", code: "370112000000", name: "历城区", parentCode: "370100000000", parentName: "山东省济南市"}},
+			newNodes: []diffNode{{level: "county", code: "370199000000", name: "历城区", parentCode: "370100000000", parentName: "山东省济南市"}},
+			want: []AreaChange{{
+				Type: ChangeRecoded, Level: "county",
+				OldCode: "370112000000", OldName: "历城区", OldParentCode: "370100000000", OldParentName: "山东省济南市",
+				NewCode: "370199000000", NewName: "历城区", NewParentCode: "370100000000", NewParentName: "山东省济南市",
+			}},
+		},
+		{
+			name:     "reparented and renamed",
+			oldNodes: []diffNode{{level: "town", code: "370116001000", name: "凤城街道", parentCode: "371202000000", parentName: "山东省莱芜市莱城区"}},
+			newNodes: []diffNode{{level: "town", code: "370116001000", name: "凤城街道办事处", parentCode: "370116000000", parentName: "山东省济南市莱芜区"}},
+			want: []AreaChange{
+				{
+					Type: ChangeReparented, Level: "town",
+					OldCode: "370116001000", OldName: "凤城街道", OldParentCode: "371202000000", OldParentName: "山东省莱芜市莱城区",
+					NewCode: "370116001000", NewName: "凤城街道办事处", NewParentCode: "370116000000", NewParentName: "山东省济南市莱芜区",
+				},
+				{
+					Type: ChangeRenamed, Level: "town",
+					OldCode: "370116001000", OldName: "凤城街道", OldParentCode: "371202000000", OldParentName: "山东省莱芜市莱城区",
+					NewCode: "370116001000", NewName: "凤城街道办事处", NewParentCode: "370116000000", NewParentName: "山东省济南市莱芜区",
+				},
+			},
+		},
+		{
+			name: "duplicate names are not matched across parents",
+			oldNodes: []diffNode{
+				{level: "county", code: "130102000000", name: "长安区", parentCode: "130100000000", parentName: "河北省石家庄市"},
+				{level: "county", code: "610116000000", name: "长安区", parentCode: "610100000000", parentName: "陕西省西安市"},
+			},
+			newNodes: []diffNode{
+				{level: "county", code: "130199000000", name: "长安区", parentCode: "130100000000", parentName: "河北省石家庄市"},
+				{level: "county", code: "610116000000", name: "长安区", parentCode: "610100000000", parentName: "陕西省西安市"},
+			},
+			want: []AreaChange{{
+				Type: ChangeRecoded, Level: "county",
+				OldCode: "130102000000", OldName: "长安区", OldParentCode: "130100000000", OldParentName: "河北省石家庄市",
+				NewCode: "130199000000", NewName: "长安区", NewParentCode: "130100000000", NewParentName: "河北省石家庄市",
+			}},
+		},
+		{
+			// 旧数据文件中没有 full_code，东莞市下属的镇均由区县代码 441900 补齐
+			name: "duplicate old codes",
+			oldNodes: []diffNode{
+				{level: "county", code: "441900000000", name: "东城街道", parentCode: "441900000000", parentName: "广东省东莞市"},
+				{level: "county", code: "441900000000", name: "南城街道", parentCode: "441900000000", parentName: "广东省东莞市"},
+			},
+			newNodes: []diffNode{
+				{level: "county", code: "441900003000", name: "东城街道", parentCode: "441900000000", parentName: "广东省东莞市"},
+				{level: "county", code: "441900004000", name: "南城街道", parentCode: "441900000000", parentName: "广东省东莞市"},
+			},
+			want: []AreaChange{
+				{
+					Type: ChangeRecoded, Level: "county",
+					OldCode: "441900000000", OldName: "东城街道", OldParentCode: "441900000000", OldParentName: "广东省东莞市",
+					NewCode: "441900003000", NewName: "东城街道", NewParentCode: "441900000000", NewParentName: "广东省东莞市",
+					OldCodeDuplicated: true,
+				},
+				{
+					Type: ChangeRecoded, Level: "county",
+					OldCode: "441900000000", OldName: "南城街道", OldParentCode: "441900000000", OldParentName: "广东省东莞市",
+					NewCode: "441900004000", NewName: "南城街道", NewParentCode: "441900000000", NewParentName: "广东省东莞市",
+					OldCodeDuplicated: true,
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffLevel(tt.oldNodes, tt.newNodes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffLevel() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffReleasesCounts(t *testing.T) {
+	oldProvinces := []Province{{
+		Code: 37, FullCode: "370000000000", Name: "山东省",
+		Cities: []City{
+			{Code: 3701, FullCode: "370100000000", Name: "济南市", Counties: []County{
+				{Code: 370181, FullCode: "370181000000", Name: "章丘市"},
+			}},
+			{Code: 3712, FullCode: "371200000000", Name: "莱芜市", Counties: []County{
+				{Code: 371202, FullCode: "371202000000", Name: "莱城区"},
+			}},
+		},
+	}}
+	newProvinces := []Province{{
+		Code: 37, FullCode: "370000000000", Name: "山东省",
+		Cities: []City{
+			{Code: 3701, FullCode: "370100000000", Name: "济南市", Counties: []County{
+				{Code: 370181, FullCode: "370181000000", Name: "章丘区"},
+				{Code: 370116, FullCode: "370116000000", Name: "莱城区"},
+				{Code: 370117, FullCode: "370117000000", Name: "钢城区"},
+			}},
+		},
+	}}
+	diff := DiffReleases("2018", oldProvinces, "2019", newProvinces)
+	want := map[string]int{
+		ChangeAbolished:  1,
+		ChangeRenamed:    1,
+		ChangeReparented: 1,
+		ChangeAdded:      1,
+	}
+	if got := diff.Counts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Counts() = %v, want %v\n%s", got, want, diff.Summary())
+	}
+}
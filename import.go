@@ -0,0 +1,188 @@
+package main
+
+import (
+	"China_area_data/clog"
+	"China_area_data/models"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strconv"
+)
+
+// 一次导入的结果
+type ImportResult struct {
+	// 新出现的区划
+	Inserted int `json:"inserted"`
+	// 名称、上级代码或城乡分类代码有变化，以及重新出现的已撤销区划
+	Updated int `json:"updated"`
+	// 本版本中不再出现，标记为撤销的区划
+	Retired int `json:"retired"`
+	// 没有变化的区划
+	Unchanged int `json:"unchanged"`
+}
+
+// 将一个版本的数据写入 province_city_region，releaseDate 为该版本的发布日期
+// 按12位区划代码(full_code)新增或更新，本版本中没有的现行区划标记 retired_at 为 releaseDate，不删除
+// 只撤销本次抓取到的层级，如只抓取到区县时已有的乡镇和村保持不变
+// 拼音、电话区号等由其它途径维护的列不会被修改；新增和撤销按批写入，整个导入在一个事务中完成，出错时全部回滚
+func ImportRelease(db *gorm.DB, provinces []Province, releaseDate string) (result ImportResult, err error) {
+	rows := prepareData(provinces)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		existing := models.ProvinceCityRegionModelList{}
+		if err := existing.GetAll(tx); err != nil {
+			return err
+		}
+		// 旧数据中 full_code 可能为空，与数据文件一样由最下一级的代码补齐后匹配
+		// 补齐后重复的行只匹配第一行，其余的按撤销处理
+		existingByCode := make(map[string]*models.ProvinceCityRegionModel, len(existing))
+		for i := range existing {
+			if fullCode := recordFullCode(existing[i]); existingByCode[fullCode] == nil {
+				existingByCode[fullCode] = &existing[i]
+			}
+		}
+
+		seen := make(map[string]bool, len(rows))
+		// 本次抓取到的层级
+		levels := make(map[string]bool)
+		inserts := make(models.ProvinceCityRegionModelList, 0)
+		for _, row := range rows {
+			fullCode := rowFullCode(row)
+			if seen[fullCode] {
+				clog.Logger.With(clog.Fields{"full_code": fullCode}).Warn("duplicate full_code in release %s, skipped", releaseDate)
+				continue
+			}
+			seen[fullCode] = true
+
+			record := models.ProvinceCityRegionModel{FullCode: fullCode}
+			applyImportRow(&record, row)
+			level, _, _ := integrityRowNode(record)
+			levels[level] = true
+
+			old, ok := existingByCode[fullCode]
+			if !ok {
+				inserts = append(inserts, record)
+				continue
+			}
+			columns := changedImportColumns(*old, row)
+			if len(columns) == 0 {
+				result.Unchanged++
+				continue
+			}
+			if err := old.UpdateColumns(tx, columns); err != nil {
+				return fmt.Errorf("update %s error:%v", fullCode, err)
+			}
+			result.Updated++
+		}
+		if err := inserts.CreateInBatches(tx); err != nil {
+			return fmt.Errorf("insert %d rows error:%v", len(inserts), err)
+		}
+		result.Inserted = len(inserts)
+
+		retired := make(models.ProvinceCityRegionModelList, 0)
+		for i := range existing {
+			old := &existing[i]
+			fullCode := recordFullCode(*old)
+			if (seen[fullCode] && existingByCode[fullCode] == old) || old.RetiredAt != "" {
+				continue
+			}
+			if level, _, _ := integrityRowNode(*old); !levels[level] {
+				continue
+			}
+			retired = append(retired, *old)
+		}
+		if err := retired.Retire(tx, releaseDate); err != nil {
+			return fmt.Errorf("retire %d rows error:%v", len(retired), err)
+		}
+		result.Retired = len(retired)
+		return nil
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return result, nil
+}
+
+// 数据行的12位区划代码，旧版本数据文件中没有 full_code 时由最下一级的代码补齐
+func rowFullCode(row ProvinceCityRegionModel) string {
+	return lowestFullCode(row.FullCode, row.ProvinceCode, row.CityCode, row.RegionCode, row.TownCode, row.VillageCode)
+}
+
+// 数据库中已有行的12位区划代码，按旧的建表语句写入的行没有 full_code，同样由最下一级的代码补齐
+func recordFullCode(record models.ProvinceCityRegionModel) string {
+	return lowestFullCode(record.FullCode, record.ProvinceCode, record.CityCode, record.RegionCode, record.TownCode, record.VillageCode)
+}
+
+// fullCode 为空时由 codes 中最后一个不为 0 的代码补齐，codes 按省、市、区县、乡镇、村的顺序
+func lowestFullCode(fullCode string, codes ...int) string {
+	if fullCode != "" {
+		return fullCode
+	}
+	for i := len(codes) - 1; i > 0; i-- {
+		if codes[i] != 0 {
+			return padFullCode(strconv.Itoa(codes[i]))
+		}
+	}
+	return padFullCode(strconv.Itoa(codes[0]))
+}
+
+func applyImportRow(record *models.ProvinceCityRegionModel, row ProvinceCityRegionModel) {
+	record.ProvinceCode = row.ProvinceCode
+	record.ProvinceName = row.ProvinceName
+	record.CityCode = row.CityCode
+	record.CityName = row.CityName
+	record.RegionCode = row.RegionCode
+	record.RegionName = row.RegionName
+	record.TownCode = row.TownCode
+	record.TownName = row.TownName
+	record.VillageCode = row.VillageCode
+	record.VillageName = row.VillageName
+	record.UrbanRuralCode = row.UrbanRuralCode
+	record.RetiredAt = ""
+}
+
+// 与数据库中已有的行比较，返回需要更新的列
+func changedImportColumns(old models.ProvinceCityRegionModel, row ProvinceCityRegionModel) map[string]interface{} {
+	updated := old
+	applyImportRow(&updated, row)
+	columns := make(map[string]interface{})
+	if updated.ProvinceCode != old.ProvinceCode {
+		columns["province_code"] = updated.ProvinceCode
+	}
+	if updated.ProvinceName != old.ProvinceName {
+		columns["province_name"] = updated.ProvinceName
+	}
+	if updated.CityCode != old.CityCode {
+		columns["city_code"] = updated.CityCode
+	}
+	if updated.CityName != old.CityName {
+		columns["city_name"] = updated.CityName
+	}
+	if updated.RegionCode != old.RegionCode {
+		columns["region_code"] = updated.RegionCode
+	}
+	if updated.RegionName != old.RegionName {
+		columns["region_name"] = updated.RegionName
+	}
+	if updated.TownCode != old.TownCode {
+		columns["town_code"] = updated.TownCode
+	}
+	if updated.TownName != old.TownName {
+		columns["town_name"] = updated.TownName
+	}
+	if updated.VillageCode != old.VillageCode {
+		columns["village_code"] = updated.VillageCode
+	}
+	if updated.VillageName != old.VillageName {
+		columns["village_name"] = updated.VillageName
+	}
+	if updated.UrbanRuralCode != old.UrbanRuralCode {
+		columns["urban_rural_code"] = updated.UrbanRuralCode
+	}
+	if updated.RetiredAt != old.RetiredAt {
+		columns["retired_at"] = updated.RetiredAt
+	}
+	// 补写旧数据中为空的 full_code
+	if fullCode := rowFullCode(row); old.FullCode != fullCode {
+		columns["full_code"] = fullCode
+	}
+	return columns
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"China_area_data/models"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"testing"
+)
+
+// 打开内存数据库并执行全部迁移
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := models.Open("sqlite3://:memory:")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := models.Migrate(db); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	return db
+}
+
+// 数据库中的全部行，按补齐后的 full_code 索引
+func importedRows(t *testing.T, db *gorm.DB) map[string]models.ProvinceCityRegionModel {
+	t.Helper()
+	list := models.ProvinceCityRegionModelList{}
+	if err := list.GetAll(db); err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	rows := make(map[string]models.ProvinceCityRegionModel, len(list))
+	for _, row := range list {
+		rows[recordFullCode(row)] = row
+	}
+	return rows
+}
+
+// 济南市和莱芜市，2019 年莱芜市撤销，莱城区划归济南市，章丘市更名为章丘区
+func shandongRelease(year string) []Province {
+	jinan := City{Code: 3701, FullCode: "370100000000", Name: "济南市", Counties: []County{
+		{Code: 370102, FullCode: "370102000000", Name: "历下区"},
+		{Code: 370181, FullCode: "370181000000", Name: "章丘市"},
+	}}
+	laiwu := City{Code: 3712, FullCode: "371200000000", Name: "莱芜市", Counties: []County{
+		{Code: 371202, FullCode: "371202000000", Name: "莱城区"},
+	}}
+	cities := []City{jinan, laiwu}
+	if year == "2019" {
+		jinan.Counties = []County{
+			{Code: 370102, FullCode: "370102000000", Name: "历下区"},
+			{Code: 370114, FullCode: "370114000000", Name: "章丘区"},
+			{Code: 370116, FullCode: "370116000000", Name: "莱芜区"},
+		}
+		cities = []City{jinan}
+	}
+	return []Province{{Code: 37, FullCode: "370000000000", Name: "山东省", Cities: cities}}
+}
+
+func TestImportRelease(t *testing.T) {
+	db := openTestDB(t)
+
+	steps := []struct {
+		name      string
+		provinces []Province
+		date      string
+		want      ImportResult
+	}{
+		{"first import", shandongRelease("2018"), "2018-10-31", ImportResult{Inserted: 6}},
+		{"same release", shandongRelease("2018"), "2018-10-31", ImportResult{Unchanged: 6}},
+		// 章丘市、莱芜市、莱城区撤销，新增章丘区、莱芜区
+		{"next release", shandongRelease("2019"), "2019-10-31", ImportResult{Inserted: 2, Retired: 3, Unchanged: 3}},
+		// 重新出现的区划恢复为现行区划，新版本中没有的再次撤销
+		{"previous release again", shandongRelease("2018"), "2020-06-30", ImportResult{Updated: 3, Retired: 2, Unchanged: 3}},
+	}
+	for _, step := range steps {
+		got, err := ImportRelease(db, step.provinces, step.date)
+		if err != nil {
+			t.Fatalf("%s: ImportRelease() error = %v", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: ImportRelease() = %+v, want %+v", step.name, got, step.want)
+		}
+	}
+
+	rows := importedRows(t, db)
+	if len(rows) != 8 {
+		t.Errorf("got %d rows, want 8", len(rows))
+	}
+	wantRetiredAt := map[string]string{
+		"370000000000": "",
+		"370100000000": "",
+		"370102000000": "",
+		"370181000000": "",
+		"371200000000": "",
+		"371202000000": "",
+		"370114000000": "2020-06-30",
+		"370116000000": "2020-06-30",
+	}
+	for fullCode, want := range wantRetiredAt {
+		if row, ok := rows[fullCode]; !ok || row.RetiredAt != want {
+			t.Errorf("%s retired_at = %q, want %q (found %v)", fullCode, row.RetiredAt, want, ok)
+		}
+	}
+}
+
+func TestImportReleaseRenameKeepsOtherColumns(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := ImportRelease(db, shandongRelease("2018"), "2018-10-31"); err != nil {
+		t.Fatal(err)
+	}
+	// 拼音、电话区号由其它途径维护
+	jinan := importedRows(t, db)["370100000000"]
+	if err := jinan.UpdateColumns(db, map[string]interface{}{"city_name_py": "jinan", "city_code_telephone": "0531"}); err != nil {
+		t.Fatal(err)
+	}
+
+	provinces := shandongRelease("2018")
+	provinces[0].Cities[0].Name = "济南"
+	got, err := ImportRelease(db, provinces, "2019-10-31")
+	if err != nil {
+		t.Fatalf("ImportRelease() error = %v", err)
+	}
+	// 城市行和下属区县行都带有城市名称
+	if want := (ImportResult{Updated: 3, Unchanged: 3}); got != want {
+		t.Errorf("ImportRelease() = %+v, want %+v", got, want)
+	}
+	row := importedRows(t, db)["370100000000"]
+	if row.CityName != "济南" || row.CityNamePy != "jinan" || row.CityCodeTelephone != "0531" || row.ID != jinan.ID {
+		t.Errorf("renamed row = %+v", row)
+	}
+}
+
+// 按 mysql 目录下旧建表语句写入的数据没有 full_code，导入时按代码匹配并补写 full_code
+func TestImportReleaseLegacyRows(t *testing.T) {
+	db := openTestDB(t)
+	legacy := []models.ProvinceCityRegionModel{
+		{ProvinceCode: 37, ProvinceName: "山东省", ProvinceNamePy: "shandong"},
+		{ProvinceCode: 37, ProvinceName: "山东省", ProvinceNamePy: "shandong", CityCode: 3701, CityName: "济南市", CityNamePy: "jinan", CityCodeTelephone: "0531"},
+		{ProvinceCode: 37, ProvinceName: "山东省", ProvinceNamePy: "shandong", CityCode: 3701, CityName: "济南市", CityNamePy: "jinan", CityCodeTelephone: "0531",
+			RegionCode: 370102, RegionName: "历下区", RegionNamePy: "lixia", Area: "100.89"},
+		{ProvinceCode: 37, ProvinceName: "山东省", ProvinceNamePy: "shandong", CityCode: 3701, CityName: "济南市", CityNamePy: "jinan", CityCodeTelephone: "0531",
+			RegionCode: 370181, RegionName: "章丘市", RegionNamePy: "zhangqiu"},
+		// 已经不存在的区划
+		{ProvinceCode: 37, ProvinceName: "山东省", ProvinceNamePy: "shandong", CityCode: 3701, CityName: "济南市", CityNamePy: "jinan",
+			RegionCode: 370199, RegionName: "测试区"},
+	}
+	for i := range legacy {
+		if err := legacy[i].Create(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	provinces := shandongRelease("2018")
+	provinces[0].Cities = provinces[0].Cities[:1]
+	got, err := ImportRelease(db, provinces, "2018-10-31")
+	if err != nil {
+		t.Fatalf("ImportRelease() error = %v", err)
+	}
+	// 匹配到的4行补写 full_code，测试区撤销，没有新增
+	if want := (ImportResult{Updated: 4, Retired: 1}); got != want {
+		t.Errorf("ImportRelease() = %+v, want %+v", got, want)
+	}
+	rows := importedRows(t, db)
+	if len(rows) != len(legacy) {
+		t.Errorf("got %d rows, want %d", len(rows), len(legacy))
+	}
+	lixia := rows["370102000000"]
+	if lixia.ID != legacy[2].ID || lixia.FullCode != "370102000000" || lixia.RegionNamePy != "lixia" || lixia.CityCodeTelephone != "0531" || lixia.Area != "100.89" || lixia.RetiredAt != "" {
+		t.Errorf("legacy row = %+v", lixia)
+	}
+	if retired := rows["370199000000"]; retired.ID != legacy[4].ID || retired.RetiredAt != "2018-10-31" {
+		t.Errorf("retired legacy row = %+v", retired)
+	}
+
+	// 补写后再次导入没有变化
+	got, err = ImportRelease(db, provinces, "2018-10-31")
+	if err != nil {
+		t.Fatalf("ImportRelease() error = %v", err)
+	}
+	if want := (ImportResult{Unchanged: 4}); got != want {
+		t.Errorf("second ImportRelease() = %+v, want %+v", got, want)
+	}
+}
+
+// 只抓取到区县的版本不撤销已有的乡镇和村
+func TestImportReleaseKeepsUnreachedLevels(t *testing.T) {
+	db := openTestDB(t)
+	withTowns := func(towns ...Town) []Province {
+		provinces := shandongRelease("2018")
+		provinces[0].Cities[0].Counties[0].Towns = towns
+		return provinces
+	}
+	jiefanglu := Town{Code: 370102001, FullCode: "370102001000", Name: "解放路街道", Villages: []Village{
+		{Code: 370102001001, FullCode: "370102001001", Name: "泉城路社区居委会", UrbanRuralCode: "111"},
+	}}
+	qianfoshan := Town{Code: 370102002, FullCode: "370102002000", Name: "千佛山街道"}
+
+	steps := []struct {
+		name      string
+		provinces []Province
+		date      string
+		want      ImportResult
+	}{
+		{"village depth", withTowns(jiefanglu, qianfoshan), "2018-10-31", ImportResult{Inserted: 9}},
+		{"county depth", shandongRelease("2018"), "2019-10-31", ImportResult{Unchanged: 6}},
+		// 抓取到乡镇时撤销不再出现的乡镇，村仍然保留
+		{"town depth", withTowns(Town{Code: jiefanglu.Code, FullCode: jiefanglu.FullCode, Name: jiefanglu.Name}), "2020-06-30", ImportResult{Retired: 1, Unchanged: 7}},
+	}
+	for _, step := range steps {
+		got, err := ImportRelease(db, step.provinces, step.date)
+		if err != nil {
+			t.Fatalf("%s: ImportRelease() error = %v", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: ImportRelease() = %+v, want %+v", step.name, got, step.want)
+		}
+	}
+	rows := importedRows(t, db)
+	wantRetiredAt := map[string]string{
+		"370102001000": "",
+		"370102001001": "",
+		"370102002000": "2020-06-30",
+	}
+	for fullCode, want := range wantRetiredAt {
+		if row, ok := rows[fullCode]; !ok || row.RetiredAt != want {
+			t.Errorf("%s retired_at = %q, want %q (found %v)", fullCode, row.RetiredAt, want, ok)
+		}
+	}
+}
+
+// 新增和撤销的行数超过一批时分多条语句写入
+func TestImportReleaseBatches(t *testing.T) {
+	db := openTestDB(t)
+	provinces := shandongRelease("2018")
+	jinan := &provinces[0].Cities[0]
+	for i := 0; i < 120; i++ {
+		town := Town{Code: 370102001 + i, FullCode: fmt.Sprintf("370102%03d000", i+1), Name: fmt.Sprintf("街道%d", i+1)}
+		jinan.Counties[0].Towns = append(jinan.Counties[0].Towns, town)
+	}
+	got, err := ImportRelease(db, provinces, "2018-10-31")
+	if err != nil {
+		t.Fatalf("ImportRelease() error = %v", err)
+	}
+	if want := (ImportResult{Inserted: 126}); got != want {
+		t.Errorf("ImportRelease() = %+v, want %+v", got, want)
+	}
+	rows := importedRows(t, db)
+	if len(rows) != 126 {
+		t.Fatalf("got %d rows, want 126", len(rows))
+	}
+	if row := rows["370102120000"]; row.TownCode != 370102120 || row.TownName != "街道120" || row.RegionName != "历下区" || row.CityName != "济南市" || row.ID == 0 {
+		t.Errorf("inserted row = %+v", row)
+	}
+
+	// 只保留第一个街道，其余 119 个撤销
+	jinan.Counties[0].Towns = jinan.Counties[0].Towns[:1]
+	got, err = ImportRelease(db, provinces, "2019-10-31")
+	if err != nil {
+		t.Fatalf("ImportRelease() error = %v", err)
+	}
+	if want := (ImportResult{Retired: 119, Unchanged: 7}); got != want {
+		t.Errorf("ImportRelease() = %+v, want %+v", got, want)
+	}
+	current := models.ProvinceCityRegionModelList{}
+	if err := current.GetAllOrderAsc(db); err != nil || len(current) != 7 {
+		t.Errorf("current rows = %d, %v, want 7", len(current), err)
+	}
+}
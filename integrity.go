@@ -0,0 +1,197 @@
+package main
+
+import (
+	"China_area_data/models"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strconv"
+	"strings"
+)
+
+// 检查报告中各层级的名称
+var integrityLevelNames = map[string]string{
+	"province": "省份",
+	"city":     "城市",
+	"county":   "区县",
+	"town":     "乡镇",
+	"village":  "村",
+}
+
+// 一处数据问题
+type IntegrityIssue struct {
+	// 数据行的层级 province、city、county、town、village
+	Level    string `json:"level"`
+	Code     int    `json:"code"`
+	FullCode string `json:"full_code"`
+	Name     string `json:"name"`
+	Detail   string `json:"detail"`
+}
+
+// 数据完整性检查结果，各项为空时数据完整
+type IntegrityReport struct {
+	// 参与检查的现行区划行数
+	Rows int `json:"rows"`
+	// 下面没有任何区县的城市
+	CitiesWithoutCounties []IntegrityIssue `json:"cities_without_counties"`
+	// 上级不存在的区县，以及上级不存在的乡镇和村
+	Orphans []IntegrityIssue `json:"orphans"`
+	// 同一层级代码重复，或 full_code 重复
+	DuplicateCodes []IntegrityIssue `json:"duplicate_codes"`
+	// 代码不以上级代码开头
+	PrefixMismatches []IntegrityIssue `json:"prefix_mismatches"`
+	// 本层级名称为空
+	EmptyNames []IntegrityIssue `json:"empty_names"`
+}
+
+func (r IntegrityReport) OK() bool {
+	return len(r.CitiesWithoutCounties) == 0 && len(r.Orphans) == 0 && len(r.DuplicateCodes) == 0 &&
+		len(r.PrefixMismatches) == 0 && len(r.EmptyNames) == 0
+}
+
+// 中文检查说明
+func (r IntegrityReport) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "共 %d 行：没有区县的城市 %d 个，上级不存在 %d 个，代码重复 %d 处，代码与上级不符 %d 个，名称为空 %d 个\n",
+		r.Rows, len(r.CitiesWithoutCounties), len(r.Orphans), len(r.DuplicateCodes), len(r.PrefixMismatches), len(r.EmptyNames))
+	sections := []struct {
+		title  string
+		issues []IntegrityIssue
+	}{
+		{"没有区县的城市", r.CitiesWithoutCounties},
+		{"上级不存在", r.Orphans},
+		{"代码重复", r.DuplicateCodes},
+		{"代码与上级不符", r.PrefixMismatches},
+		{"名称为空", r.EmptyNames},
+	}
+	for _, section := range sections {
+		for _, issue := range section.issues {
+			fmt.Fprintf(&b, "%s %s %s(%d, full_code %s)：%s\n", section.title, integrityLevelNames[issue.Level], issue.Name, issue.Code, issue.FullCode, issue.Detail)
+		}
+	}
+	return b.String()
+}
+
+// 检查数据库中的现行区划，只查询一次，在内存中完成全部检查
+func verifyDataIntegrity(db *gorm.DB) (IntegrityReport, error) {
+	areaList := models.ProvinceCityRegionModelList{}
+	if err := areaList.GetAllOrderAsc(db); err != nil {
+		return IntegrityReport{}, err
+	}
+	return CheckIntegrity(areaList), nil
+}
+
+// 检查 province_city_region 的数据行，保证完整的三级结构且代码、名称有效
+func CheckIntegrity(rows models.ProvinceCityRegionModelList) IntegrityReport {
+	report := IntegrityReport{Rows: len(rows)}
+	// 各层级已有的代码
+	codes := map[string]map[int]bool{
+		"province": {},
+		"city":     {},
+		"county":   {},
+		"town":     {},
+	}
+	// 有区县的城市
+	citiesWithCounty := make(map[int]bool)
+	levelCodeCount := make(map[string]int)
+	fullCodeCount := make(map[string]int)
+	for _, row := range rows {
+		level, code, _ := integrityRowNode(row)
+		if levelCodes, ok := codes[level]; ok {
+			levelCodes[code] = true
+		}
+		if row.RegionCode != 0 {
+			citiesWithCounty[row.CityCode] = true
+		}
+		levelCodeCount[level+":"+strconv.Itoa(code)]++
+		if row.FullCode != "" {
+			fullCodeCount[row.FullCode]++
+		}
+	}
+
+	reportedCodes := make(map[string]bool)
+	for _, row := range rows {
+		level, code, name := integrityRowNode(row)
+		issue := IntegrityIssue{Level: level, Code: code, FullCode: row.FullCode, Name: name}
+		if level == "city" && !citiesWithCounty[code] {
+			issue.Detail = "没有下属区县"
+			report.CitiesWithoutCounties = append(report.CitiesWithoutCounties, issue)
+		}
+		if parentLevel, parentCode, ok := integrityParent(row, level); ok && !codes[parentLevel][parentCode] {
+			issue.Detail = fmt.Sprintf("上级%s %d 不存在", integrityLevelNames[parentLevel], parentCode)
+			report.Orphans = append(report.Orphans, issue)
+		}
+		key := level + ":" + strconv.Itoa(code)
+		if levelCodeCount[key] > 1 && !reportedCodes[key] {
+			reportedCodes[key] = true
+			issue.Detail = fmt.Sprintf("%s代码出现 %d 次", integrityLevelNames[level], levelCodeCount[key])
+			report.DuplicateCodes = append(report.DuplicateCodes, issue)
+		}
+		if fullCodeCount[row.FullCode] > 1 && !reportedCodes[row.FullCode] {
+			reportedCodes[row.FullCode] = true
+			issue.Detail = fmt.Sprintf("full_code 出现 %d 次", fullCodeCount[row.FullCode])
+			report.DuplicateCodes = append(report.DuplicateCodes, issue)
+		}
+		if prefix := integrityPrefix(row, level); prefix != 0 && !strings.HasPrefix(strconv.Itoa(code), strconv.Itoa(prefix)) {
+			issue.Detail = fmt.Sprintf("代码不以上级代码 %d 开头", prefix)
+			report.PrefixMismatches = append(report.PrefixMismatches, issue)
+		}
+		if strings.TrimSpace(name) == "" {
+			issue.Detail = "名称为空"
+			report.EmptyNames = append(report.EmptyNames, issue)
+		}
+	}
+	return report
+}
+
+// 数据行的层级及本层级的代码和名称，层级划分与导出 CSV 时相同
+func integrityRowNode(row models.ProvinceCityRegionModel) (level string, code int, name string) {
+	switch {
+	case row.CityCode == 0:
+		return "province", row.ProvinceCode, row.ProvinceName
+	case row.RegionCode == 0:
+		return "city", row.CityCode, row.CityName
+	case row.VillageCode != 0:
+		return "village", row.VillageCode, row.VillageName
+	case row.TownCode == 0:
+		return "county", row.RegionCode, row.RegionName
+	default:
+		return "town", row.TownCode, row.TownName
+	}
+}
+
+// 上级的层级和代码，东莞市等城市的村直接挂在第三级下面
+func integrityParent(row models.ProvinceCityRegionModel, level string) (string, int, bool) {
+	switch level {
+	case "city":
+		return "province", row.ProvinceCode, true
+	case "county":
+		return "city", row.CityCode, true
+	case "town":
+		return "county", row.RegionCode, true
+	case "village":
+		if row.TownCode == 0 {
+			return "county", row.RegionCode, true
+		}
+		return "town", row.TownCode, true
+	}
+	return "", 0, false
+}
+
+// 代码应当以之开头的上级代码，为 0 时不检查
+// 东莞市等城市的第三级为合成代码，其下的村只检查是否以城市代码开头
+func integrityPrefix(row models.ProvinceCityRegionModel, level string) int {
+	switch level {
+	case "city":
+		return row.ProvinceCode
+	case "county":
+		return row.CityCode
+	case "town":
+		return row.RegionCode
+	case "village":
+		if row.TownCode == 0 {
+			return row.CityCode
+		}
+		return row.TownCode
+	}
+	return 0
+}
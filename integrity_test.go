@@ -0,0 +1,141 @@
+package main
+
+import (
+	"China_area_data/models"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func integrityProvince(code int, name string) models.ProvinceCityRegionModel {
+	return models.ProvinceCityRegionModel{ProvinceCode: code, ProvinceName: name, FullCode: padFullCode(strconv.Itoa(code))}
+}
+
+func integrityCity(parent models.ProvinceCityRegionModel, code int, name string) models.ProvinceCityRegionModel {
+	row := parent
+	row.CityCode, row.CityName, row.FullCode = code, name, padFullCode(strconv.Itoa(code))
+	return row
+}
+
+func integrityCounty(parent models.ProvinceCityRegionModel, code int, name string) models.ProvinceCityRegionModel {
+	row := parent
+	row.RegionCode, row.RegionName, row.FullCode = code, name, padFullCode(strconv.Itoa(code))
+	return row
+}
+
+func integrityTown(parent models.ProvinceCityRegionModel, code int, name string) models.ProvinceCityRegionModel {
+	row := parent
+	row.TownCode, row.TownName, row.FullCode = code, name, padFullCode(strconv.Itoa(code))
+	return row
+}
+
+func integrityVillage(parent models.ProvinceCityRegionModel, code int, name string) models.ProvinceCityRegionModel {
+	row := parent
+	row.VillageCode, row.VillageName, row.UrbanRuralCode, row.FullCode = code, name, "111", strconv.Itoa(code)
+	return row
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	shandong := integrityProvince(37, "山东省")
+	jinan := integrityCity(shandong, 3701, "济南市")
+	lixia := integrityCounty(jinan, 370102, "历下区")
+	jiefanglu := integrityTown(lixia, 370102001, "解放路街道")
+	guangdong := integrityProvince(44, "广东省")
+	dongguan := integrityCity(guangdong, 4419, "东莞市")
+	// 东莞市不设区县，街道作为第三级，村直接挂在第三级下面
+	guancheng := integrityCounty(dongguan, 441901, "莞城街道")
+	valid := models.ProvinceCityRegionModelList{
+		shandong, jinan, lixia, jiefanglu,
+		integrityVillage(jiefanglu, 370102001001, "泉城路社区居委会"),
+		guangdong, dongguan, guancheng,
+		integrityVillage(guancheng, 441900003001, "东正社区居委会"),
+	}
+
+	emptyName := integrityCounty(jinan, 370103, "")
+	wrongCityCode := integrityCounty(jinan, 370302, "张店区")
+	zibo := integrityCity(shandong, 3703, "淄博市")
+	// 与历下区的 full_code 相同
+	huaiyin := integrityCounty(jinan, 370104, "槐荫区")
+	huaiyin.FullCode = lixia.FullCode
+	tests := []struct {
+		name string
+		rows models.ProvinceCityRegionModelList
+		// 各项检查的问题数量：没有区县的城市、上级不存在、代码重复、代码与上级不符、名称为空
+		want [5]int
+		// 报告中应当出现的说明
+		wantSummary []string
+	}{
+		{
+			name: "valid",
+			rows: valid,
+		},
+		{
+			name:        "city without counties",
+			rows:        append(valid[:len(valid):len(valid)], integrityCity(shandong, 3712, "莱芜市")),
+			want:        [5]int{1, 0, 0, 0, 0},
+			wantSummary: []string{"没有区县的城市 城市 莱芜市(3712, full_code 371200000000)：没有下属区县"},
+		},
+		{
+			name: "orphans",
+			rows: append(valid[:len(valid):len(valid)],
+				// 淄博市的数据行缺失
+				integrityCounty(zibo, 370302, "淄川区"),
+				integrityTown(integrityCounty(jinan, 370199, "不存在区"), 370199001, "不存在街道"),
+				integrityVillage(integrityTown(lixia, 370102099, "不存在街道"), 370102099001, "某社区居委会"),
+			),
+			want:        [5]int{0, 3, 0, 0, 0},
+			wantSummary: []string{"上级城市 3703 不存在", "上级区县 370199 不存在", "上级乡镇 370102099 不存在"},
+		},
+		{
+			name:        "duplicate codes",
+			rows:        append(valid[:len(valid):len(valid)], integrityCounty(jinan, 370102, "历下区")),
+			want:        [5]int{0, 0, 2, 0, 0},
+			wantSummary: []string{"区县代码出现 2 次", "full_code 出现 2 次"},
+		},
+		{
+			name:        "duplicate full code",
+			rows:        append(valid[:len(valid):len(valid)], huaiyin),
+			want:        [5]int{0, 0, 1, 0, 0},
+			wantSummary: []string{"full_code 出现 2 次"},
+		},
+		{
+			name:        "prefix mismatch",
+			rows:        append(valid[:len(valid):len(valid)], wrongCityCode),
+			want:        [5]int{0, 0, 0, 1, 0},
+			wantSummary: []string{"代码与上级不符 区县 张店区(370302, full_code 370302000000)：代码不以上级代码 3701 开头"},
+		},
+		{
+			name:        "village prefix mismatch",
+			rows:        append(valid[:len(valid):len(valid)], integrityVillage(guancheng, 370102001002, "错误社区居委会")),
+			want:        [5]int{0, 0, 0, 1, 0},
+			wantSummary: []string{"代码与上级不符 村 错误社区居委会"},
+		},
+		{
+			name:        "empty names",
+			rows:        append(valid[:len(valid):len(valid)], emptyName, integrityTown(lixia, 370102002, " ")),
+			want:        [5]int{0, 0, 0, 0, 2},
+			wantSummary: []string{"名称为空 区县 (370103, full_code 370103000000)", "名称为空 乡镇"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := CheckIntegrity(tt.rows)
+			got := [5]int{len(report.CitiesWithoutCounties), len(report.Orphans), len(report.DuplicateCodes), len(report.PrefixMismatches), len(report.EmptyNames)}
+			summary := report.Summary()
+			if got != tt.want {
+				t.Errorf("CheckIntegrity() counts = %v, want %v\n%s", got, tt.want, summary)
+			}
+			if report.Rows != len(tt.rows) {
+				t.Errorf("Rows = %d, want %d", report.Rows, len(tt.rows))
+			}
+			if report.OK() != (tt.want == [5]int{}) {
+				t.Errorf("OK() = %v", report.OK())
+			}
+			for _, want := range tt.wantSummary {
+				if !strings.Contains(summary, want) {
+					t.Errorf("Summary() does not contain %q:\n%s", want, summary)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 表格中的一行，href 为空时没有下级页面
+func areaRow(rowClass string, href string, code string, name string) string {
+	if href == "" {
+		return fmt.Sprintf("<tr class='%s'><td>%s</td><td>%s</td></tr>", rowClass, code, name)
+	}
+	return fmt.Sprintf("<tr class='%s'><td><a href='%s'>%s</a></td><td><a href='%s'>%s</a></td></tr>", rowClass, href, code, href, name)
+}
+
+func areaTable(tableClass string, rows ...string) string {
+	return fmt.Sprintf("<table class='%s'><tbody>%s</tbody></table>", tableClass, strings.Join(rows, ""))
+}
+
+func villageRow(code string, urbanRuralCode string, name string) string {
+	return fmt.Sprintf("<tr class='villagetr'><td>%s</td><td>%s</td><td>%s</td></tr>", code, urbanRuralCode, name)
+}
+
+// 按国家统计局网站的页面结构模拟的 2020 年数据：山东省济南市的两个区县，以及不设区县的东莞市
+var statsSitePages = map[string]string{
+	"/": "<div class='center'><div class='center_list'><ul class='center_list_contlist'>" +
+		"<li><a href='2020/index.html'><span><font class='cont_tit03'>2020年</font><font class='cont_tit02'>2020-11-06</font></span></a></li>" +
+		"<li><a href='2019/index.html'><span><font class='cont_tit03'>2019年</font><font class='cont_tit02'>2020-02-25</font></span></a></li>" +
+		"</ul></div></div>",
+	"/2020/": "<table><tbody><tr class='provincetr'><td><a href='37.html'>山东省</a></td><td><a href='44.html'>广东省</a></td><td></td></tr></tbody></table>",
+	"/2020/37.html": areaTable("citytable",
+		areaRow("citytr", "37/3701.html", "370100000000", "济南市")),
+	"/2020/44.html": areaTable("citytable",
+		areaRow("citytr", "44/4419.html", "441900000000", "东莞市")),
+	"/2020/37/3701.html": areaTable("countytable",
+		areaRow("countytr", "", "370101000000", "市辖区"),
+		areaRow("countytr", "01/370102.html", "370102000000", "历下区"),
+		areaRow("countytr", "01/370103.html", "370103000000", "市中区")),
+	// 东莞市的城市页面中直接是乡镇表
+	"/2020/44/4419.html": areaTable("towntable",
+		areaRow("towntr", "19/441900003.html", "441900003000", "东城街道"),
+		areaRow("towntr", "19/441900004.html", "441900004000", "南城街道")),
+	"/2020/37/01/370102.html": areaTable("towntable",
+		areaRow("towntr", "02/370102001.html", "370102001000", "解放路街道"),
+		areaRow("towntr", "02/370102002.html", "370102002000", "千佛山街道")),
+	"/2020/37/01/370103.html": areaTable("towntable",
+		areaRow("towntr", "03/370103001.html", "370103001000", "大观园街道")),
+	// 儋州市同样不设区县，没有从省份页面链接，只用于单独解析城市页面
+	"/2020/46/4604.html": areaTable("towntable",
+		areaRow("towntr", "04/460400100.html", "460400100000", "那大镇"),
+		areaRow("towntr", "04/460400101.html", "460400101000", "和庆镇")),
+	"/2020/46/4699.html": "<p>页面不存在</p>",
+	"/2020/37/01/02/370102001.html": areaTable("villagetable",
+		villageRow("370102001001", "111", "泉城路社区居委会"),
+		villageRow("370102001002", "111", "县西巷社区居委会")),
+	"/2020/37/01/02/370102002.html": areaTable("villagetable",
+		villageRow("370102002001", "111", "千佛山社区居委会")),
+	"/2020/37/01/03/370103001.html": areaTable("villagetable",
+		villageRow("370103001001", "111", "大观园社区居委会")),
+	"/2020/44/19/441900003.html": areaTable("villagetable",
+		villageRow("441900003001", "111", "东泰社区居委会")),
+	"/2020/44/19/441900004.html": areaTable("villagetable",
+		villageRow("441900004001", "111", "宏远社区居委会")),
+}
+
+// 模拟的国家统计局网站，记录每个页面的请求次数，个别页面可以替换为其它处理
+type statsSite struct {
+	*httptest.Server
+	mu        sync.Mutex
+	requests  map[string]int
+	overrides map[string]http.HandlerFunc
+}
+
+func newStatsSite(t *testing.T) *statsSite {
+	site := &statsSite{requests: make(map[string]int), overrides: make(map[string]http.HandlerFunc)}
+	site.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		site.mu.Lock()
+		site.requests[r.URL.Path]++
+		override := site.overrides[r.URL.Path]
+		site.mu.Unlock()
+		if override != nil {
+			override(w, r)
+			return
+		}
+		page, ok := statsSitePages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<html><head><meta charset='utf-8'></head><body>%s</body></html>", page)
+	}))
+	t.Cleanup(site.Close)
+	return site
+}
+
+// 替换 path 的处理，handler 为空时恢复为正常页面
+func (site *statsSite) override(path string, handler http.HandlerFunc) {
+	site.mu.Lock()
+	defer site.mu.Unlock()
+	if handler == nil {
+		delete(site.overrides, path)
+		return
+	}
+	site.overrides[path] = handler
+}
+
+// path 的请求次数
+func (site *statsSite) requestCount(path string) int {
+	site.mu.Lock()
+	defer site.mu.Unlock()
+	return site.requests[path]
+}
+
+// 请求过的页面路径，并清空请求记录
+func (site *statsSite) takeRequests() []string {
+	site.mu.Lock()
+	defer site.mu.Unlock()
+	paths := make([]string, 0, len(site.requests))
+	for path := range site.requests {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	site.requests = make(map[string]int)
+	return paths
+}
+
+// 抓取模拟网站的会话，不限速、不缓存、不记录断点，页面只尝试一次
+func newTestSession(t *testing.T, site *statsSite, configure func(options *CrawlOptions)) *CrawlerSession {
+	t.Helper()
+	config := DefaultCrawlerConfig
+	config.BaseUrl = site.URL + "/"
+	options := CrawlOptions{
+		MaxLevel:       LevelTown,
+		Parallelism:    4,
+		MaxAttempts:    1,
+		RetryBaseDelay: time.Millisecond,
+	}
+	if configure != nil {
+		configure(&options)
+	}
+	session, err := NewCrawlerSession(config, options, SessionOptions{})
+	if err != nil {
+		t.Fatalf("NewCrawlerSession() error = %v", err)
+	}
+	return session
+}
+
+// 测试用的临时目录，测试结束后删除
+func testTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "china_area_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// 按层级缩进列出抓取结果中的代码和名称，便于比较
+func areaOutline(provinces []Province) []string {
+	lines := make([]string, 0)
+	for _, p := range provinces {
+		lines = append(lines, fmt.Sprintf("%s %s", p.FullCode, p.Name))
+		for _, city := range p.Cities {
+			lines = append(lines, fmt.Sprintf("  %s %s", city.FullCode, city.Name))
+			for _, county := range city.Counties {
+				lines = append(lines, fmt.Sprintf("    %d %s %s", county.Code, county.FullCode, county.Name))
+				for _, village := range county.Villages {
+					lines = append(lines, fmt.Sprintf("      %s %s", village.FullCode, village.Name))
+				}
+				for _, town := range county.Towns {
+					lines = append(lines, fmt.Sprintf("      %s %s", town.FullCode, town.Name))
+					for _, village := range town.Villages {
+						lines = append(lines, fmt.Sprintf("        %s %s %s", village.FullCode, village.UrbanRuralCode, village.Name))
+					}
+				}
+			}
+		}
+	}
+	return lines
+}
+
+func TestGetProvinceUrlAndData(t *testing.T) {
+	site := newStatsSite(t)
+	tests := []struct {
+		maxLevel int
+		want     []string
+	}{
+		{LevelCounty, []string{
+			"370000000000 山东省",
+			"  370100000000 济南市",
+			"    370101 370101000000 市辖区",
+			"    370102 370102000000 历下区",
+			"    370103 370103000000 市中区",
+			"440000000000 广东省",
+			"  441900000000 东莞市",
+			"    441900 441900003000 东城街道",
+			"    441900 441900004000 南城街道",
+		}},
+		{LevelVillage, []string{
+			"370000000000 山东省",
+			"  370100000000 济南市",
+			"    370101 370101000000 市辖区",
+			"    370102 370102000000 历下区",
+			"      370102001000 解放路街道",
+			"        370102001001 111 泉城路社区居委会",
+			"        370102001002 111 县西巷社区居委会",
+			"      370102002000 千佛山街道",
+			"        370102002001 111 千佛山社区居委会",
+			"    370103 370103000000 市中区",
+			"      370103001000 大观园街道",
+			"        370103001001 111 大观园社区居委会",
+			"440000000000 广东省",
+			"  441900000000 东莞市",
+			"    441900 441900003000 东城街道",
+			"      441900003001 东泰社区居委会",
+			"    441900 441900004000 南城街道",
+			"      441900004001 宏远社区居委会",
+		}},
+	}
+	for _, tt := range tests {
+		session := newTestSession(t, site, func(options *CrawlOptions) { options.MaxLevel = tt.maxLevel })
+		provinces, err := session.GetProvinceUrlAndData(context.Background(), site.URL+"/2020/")
+		if err != nil {
+			t.Fatalf("GetProvinceUrlAndData() max level %d error = %v", tt.maxLevel, err)
+		}
+		if got := areaOutline(provinces); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("GetProvinceUrlAndData() max level %d =\n%s\nwant\n%s", tt.maxLevel, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+// 城市页面中是区县表还是乡镇表由页面结构判断
+func TestGetCityChildren(t *testing.T) {
+	site := newStatsSite(t)
+	session := newTestSession(t, site, nil)
+	prefixUrl := site.URL + "/2020/"
+	tests := []struct {
+		path             string
+		wantTownAsCounty bool
+		want             []County
+		wantErr          string
+	}{
+		{
+			path: "/2020/37/3701.html",
+			want: []County{
+				{Code: 370101, FullCode: "370101000000", Name: "市辖区"},
+				{Code: 370102, FullCode: "370102000000", Name: "历下区", Link: prefixUrl + "37/01/370102.html"},
+				{Code: 370103, FullCode: "370103000000", Name: "市中区", Link: prefixUrl + "37/01/370103.html"},
+			},
+		},
+		{
+			path:             "/2020/44/4419.html",
+			wantTownAsCounty: true,
+			want: []County{
+				{Code: 441900, FullCode: "441900003000", Name: "东城街道", Link: prefixUrl + "44/19/441900003.html"},
+				{Code: 441900, FullCode: "441900004000", Name: "南城街道", Link: prefixUrl + "44/19/441900004.html"},
+			},
+		},
+		{
+			path:             "/2020/46/4604.html",
+			wantTownAsCounty: true,
+			want: []County{
+				{Code: 460400, FullCode: "460400100000", Name: "那大镇", Link: prefixUrl + "46/04/460400100.html"},
+				{Code: 460400, FullCode: "460400101000", Name: "和庆镇", Link: prefixUrl + "46/04/460400101.html"},
+			},
+		},
+		{path: "/2020/46/4699.html", wantErr: "既没有区县表也没有乡镇表"},
+		{path: "/2020/46/4698.html", wantErr: "Not Found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			counties, townAsCounty, err := session.GetCityChildren(context.Background(), prefixUrl, site.URL+tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("GetCityChildren() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetCityChildren() error = %v", err)
+			}
+			if townAsCounty != tt.wantTownAsCounty || !reflect.DeepEqual(counties, tt.want) {
+				t.Errorf("GetCityChildren() = %+v, %v, want %+v, %v", counties, townAsCounty, tt.want, tt.wantTownAsCounty)
+			}
+		})
+	}
+}
+
+// 个别页面多次重试仍失败时记录失败，其余数据照常返回
+func TestGetProvinceUrlAndDataPartial(t *testing.T) {
+	site := newStatsSite(t)
+	failedPath := "/2020/37/01/370102.html"
+	site.override(failedPath, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusInternalServerError)
+	})
+	session := newTestSession(t, site, func(options *CrawlOptions) { options.MaxAttempts = 2 })
+	provinces, err := session.GetProvinceUrlAndData(context.Background(), site.URL+"/2020/")
+	var partialErr *PartialCrawlError
+	if !errors.As(err, &partialErr) || partialErr.Cause != nil {
+		t.Fatalf("GetProvinceUrlAndData() error = %v, want *PartialCrawlError", err)
+	}
+	if len(partialErr.Failures) != 1 {
+		t.Fatalf("Failures = %+v, want 1 failure", partialErr.Failures)
+	}
+	failure := partialErr.Failures[0]
+	if failure.Level != "county" || failure.Name != "历下区" || failure.Url != site.URL+failedPath || failure.Attempts != 2 || !strings.Contains(failure.Error, "Internal Server Error") {
+		t.Errorf("Failures[0] = %+v", failure)
+	}
+	if n := site.requestCount(failedPath); n != 2 {
+		t.Errorf("%s requested %d times, want 2", failedPath, n)
+	}
+
+	// 历下区没有乡镇，其余数据完整
+	want := []string{
+		"370000000000 山东省",
+		"  370100000000 济南市",
+		"    370101 370101000000 市辖区",
+		"    370102 370102000000 历下区",
+		"    370103 370103000000 市中区",
+		"      370103001000 大观园街道",
+		"440000000000 广东省",
+		"  441900000000 东莞市",
+		"    441900 441900003000 东城街道",
+		"    441900 441900004000 南城街道",
+	}
+	if got := areaOutline(provinces); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("GetProvinceUrlAndData() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// 离线模式只读缓存，缓存未命中的页面直接失败，不会请求网站
+func TestGetProvinceUrlAndDataOffline(t *testing.T) {
+	site := newStatsSite(t)
+	cacheDir := testTempDir(t)
+	offline := func(options *CrawlOptions) {
+		options.Offline = true
+		options.CacheDir = cacheDir
+		options.MaxAttempts = 3
+	}
+	_, err := newTestSession(t, site, offline).GetProvinceUrlAndData(context.Background(), site.URL+"/2020/")
+	if err == nil || !strings.Contains(err.Error(), "离线模式下缓存中没有") {
+		t.Errorf("GetProvinceUrlAndData() with empty cache error = %v", err)
+	}
+	if paths := site.takeRequests(); len(paths) != 0 {
+		t.Errorf("offline crawl requested %v", paths)
+	}
+
+	online := newTestSession(t, site, func(options *CrawlOptions) { options.CacheDir = cacheDir })
+	if _, err := online.GetProvinceUrlAndData(context.Background(), site.URL+"/2020/"); err != nil {
+		t.Fatalf("GetProvinceUrlAndData() online error = %v", err)
+	}
+	site.takeRequests()
+	missingUrl := site.URL + "/2020/37/01/370102.html"
+	os.Remove(cacheFilePath(cacheDir, missingUrl))
+
+	provinces, err := newTestSession(t, site, offline).GetProvinceUrlAndData(context.Background(), site.URL+"/2020/")
+	var partialErr *PartialCrawlError
+	if !errors.As(err, &partialErr) || partialErr.Cause != nil || len(partialErr.Failures) != 1 {
+		t.Fatalf("GetProvinceUrlAndData() error = %v, want *PartialCrawlError with 1 failure", err)
+	}
+	// 离线模式下不重试
+	failure := partialErr.Failures[0]
+	if failure.Url != missingUrl || failure.Attempts != 1 || !strings.Contains(failure.Error, "离线模式下缓存中没有") {
+		t.Errorf("Failures[0] = %+v", failure)
+	}
+	if paths := site.takeRequests(); len(paths) != 0 {
+		t.Errorf("offline crawl requested %v", paths)
+	}
+	// 其余页面从缓存读取
+	if got := areaOutline(provinces); len(got) != 10 || got[len(got)-1] != "    441900 441900004000 南城街道" {
+		t.Errorf("GetProvinceUrlAndData() offline =\n%s", strings.Join(got, "\n"))
+	}
+}
+
+// 联网抓取发布记录成功后才替换缓存，抓取失败时离线模式仍可使用上次的缓存
+func TestGetPublishRecordCache(t *testing.T) {
+	site := newStatsSite(t)
+	cacheDir := testTempDir(t)
+	online := newTestSession(t, site, func(options *CrawlOptions) { options.CacheDir = cacheDir })
+	offline := newTestSession(t, site, func(options *CrawlOptions) {
+		options.CacheDir = cacheDir
+		options.Offline = true
+	})
+	want := []PublishRecord{
+		{Year: "2020", Date: "2020-11-06", Link: site.URL + "/2020/"},
+		{Year: "2019", Date: "2020-02-25", Link: site.URL + "/2019/"},
+	}
+
+	if _, err := offline.GetPublishRecord(context.Background()); err == nil {
+		t.Errorf("GetPublishRecord() offline with empty cache error = nil")
+	}
+	for i := 0; i < 2; i++ {
+		records, err := online.GetPublishRecord(context.Background())
+		if err != nil || !reflect.DeepEqual(records, want) {
+			t.Fatalf("GetPublishRecord() = %+v, %v, want %+v", records, err, want)
+		}
+	}
+	// 联网时每次都重新抓取
+	if n := site.requestCount("/"); n != 2 {
+		t.Errorf("/ requested %d times, want 2", n)
+	}
+
+	failures := map[string]http.HandlerFunc{
+		"server error": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "busy", http.StatusInternalServerError)
+		},
+		"not found": http.NotFound,
+		"no records": func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "<html><body>维护中</body></html>")
+		},
+	}
+	for name, handler := range failures {
+		site.override("/", handler)
+		if records, err := online.GetPublishRecord(context.Background()); err == nil && len(records) > 0 {
+			t.Errorf("GetPublishRecord() with %s = %+v", name, records)
+		}
+		site.takeRequests()
+		records, err := offline.GetPublishRecord(context.Background())
+		if err != nil || !reflect.DeepEqual(records, want) {
+			t.Errorf("GetPublishRecord() offline after %s = %+v, %v, want %+v", name, records, err, want)
+		}
+		if paths := site.takeRequests(); len(paths) != 0 {
+			t.Errorf("offline GetPublishRecord() requested %v", paths)
+		}
+	}
+	// 只留下发布记录目录，不残留临时目录
+	if infos, err := ioutil.ReadDir(cacheDir); err != nil || len(infos) != 1 || infos[0].Name() != indexCacheNamespace {
+		t.Errorf("cache dir entries = %v, %v", infos, err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"China_area_data/clog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// 抓取和导出任务的监控指标，通过 -metrics-addr 开启 /metrics 后由 Prometheus 采集
+var (
+	// 抓取并解析成功的页面数，level 与 CrawlFailure.Level 相同
+	metricPagesFetched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "china_area",
+		Name:      "pages_fetched_total",
+		Help:      "抓取并解析成功的页面数",
+	}, []string{"level"})
+	// 多次重试后仍然失败的页面数
+	metricPagesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "china_area",
+		Name:      "pages_failed_total",
+		Help:      "多次重试后仍然失败的页面数",
+	}, []string{"level"})
+	// 页面缓存命中情况，result 为 hit 或 miss
+	metricCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "china_area",
+		Name:      "cache_requests_total",
+		Help:      "页面缓存命中和未命中次数",
+	}, []string{"result"})
+	// 实际发出的 http 请求，code 为状态码，请求失败时为 error
+	metricHttpResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "china_area",
+		Name:      "http_responses_total",
+		Help:      "按状态码统计的 http 响应数",
+	}, []string{"code"})
+	metricHttpDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "china_area",
+		Name:      "http_request_duration_seconds",
+		Help:      "http 请求耗时",
+		Buckets:   prometheus.DefBuckets,
+	})
+	// 页面中无法解析的行数
+	metricParseFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "china_area",
+		Name:      "parse_failures_total",
+		Help:      "页面中无法解析的行数",
+	}, []string{"level"})
+	// 整个版本的抓取耗时，result 为 success、partial 或 error
+	metricCrawlDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "china_area",
+		Name:      "crawl_duration_seconds",
+		Help:      "整个版本的抓取耗时",
+		Buckets:   prometheus.ExponentialBuckets(60, 2, 10),
+	}, []string{"result"})
+	// 最近一次抓取得到的各层级节点数
+	metricNodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "china_area",
+		Name:      "nodes",
+		Help:      "最近一次抓取得到的各层级节点数",
+	}, []string{"level"})
+	// 导出 CSV 压缩包的耗时，result 为 success 或 error
+	metricExportDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "china_area",
+		Name:      "export_duration_seconds",
+		Help:      "导出 CSV 压缩包的耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+	// 最近一次导出的各 CSV 文件行数(不含表头)
+	metricExportRows = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "china_area",
+		Name:      "export_rows",
+		Help:      "最近一次导出的各 CSV 文件行数",
+	}, []string{"file"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		metricPagesFetched,
+		metricPagesFailed,
+		metricCacheRequests,
+		metricHttpResponses,
+		metricHttpDuration,
+		metricParseFailures,
+		metricCrawlDuration,
+		metricNodes,
+		metricExportDuration,
+		metricExportRows,
+	)
+}
+
+// 在 addr 上开启 /metrics，任务运行期间在后台提供服务
+func ServeMetrics(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			clog.Logger.Error("metrics server %s err: %v", addr, err)
+		}
+	}()
+	return server
+}
+
+// 记录抓取耗时和各层级节点数
+func observeCrawl(start time.Time, provinces []Province, err error) {
+	result := "success"
+	if _, ok := err.(*PartialCrawlError); ok {
+		result = "partial"
+	} else if err != nil {
+		result = "error"
+	}
+	metricCrawlDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	if result == "error" {
+		return
+	}
+	counts := map[string]int{"province": len(provinces)}
+	for _, p := range provinces {
+		counts["city"] += len(p.Cities)
+		for _, city := range p.Cities {
+			counts["county"] += len(city.Counties)
+			for _, county := range city.Counties {
+				counts["town"] += len(county.Towns)
+				counts["village"] += len(county.Villages)
+				for _, town := range county.Towns {
+					counts["village"] += len(town.Villages)
+				}
+			}
+		}
+	}
+	for level, count := range counts {
+		metricNodes.WithLabelValues(level).Set(float64(count))
+	}
+}
+
+// 记录导出耗时
+func observeExport(start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	metricExportDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+}
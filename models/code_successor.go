@@ -0,0 +1,42 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+// 区划代码新旧对照，一个旧代码可以对应多个新代码；新增的区划也记录一条，用于判断撤销的代码是否被重新启用
+// create_time 由数据库默认值填充
+type CodeSuccessor struct {
+	Id int `gorm:"column:id" form:"id"`
+	// 区划的层级 province/city/county/town，不同层级的代码补齐为12位后可能相同，如东莞市和旧数据中其下属的镇
+	// 早期写入的记录为空，按代码推断
+	Level string `gorm:"column:level" form:"level"`
+	// 旧的12位区划代码，新增的区划为空
+	OldCode string `gorm:"column:old_code" form:"old_code"`
+	// 新的12位区划代码，撤销且没有继承的区划为空
+	NewCode string `gorm:"column:new_code" form:"new_code"`
+	// 变更类型 recoded/reparented/abolished/added
+	ChangeType string `gorm:"column:change_type" form:"change_type"`
+	// 生效的发布版本
+	EffectiveDate string `gorm:"column:effective_date" form:"effective_date"`
+}
+
+type CodeSuccessorList []CodeSuccessor
+
+func (c *CodeSuccessor) TableName() string {
+	return "code_successor"
+}
+
+// 同一版本的对照关系已存在时不重复写入
+func (c *CodeSuccessor) Create(db *gorm.DB) error {
+	var count int
+	err := db.Table(c.TableName()).Where("old_code = ? and new_code = ? and effective_date = ?", c.OldCode, c.NewCode, c.EffectiveDate).Count(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+	return db.Table(c.TableName()).Create(c).Error
+}
+
+// 按生效版本先后获取所有对照关系
+func (list *CodeSuccessorList) GetAll(db *gorm.DB) error {
+	var c CodeSuccessor
+	return db.Table(c.TableName()).Order("effective_date, id").Find(list).Error
+}
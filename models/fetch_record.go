@@ -1,7 +1,6 @@
 package models
 
-// 此处自行导入 gorm
-import "gorm"
+import "github.com/jinzhu/gorm"
 
 // 存储国家统计局数据上传到oss的链接记录
 type FetchRecord struct {
@@ -12,6 +11,8 @@ type FetchRecord struct {
 	UpdateAt string `gorm:"column:update_at" form:"update_at"`
 	// 省市区数据oss下载链接
 	DownUrl string `gorm:"column:down_url" form:"down_url"`
+	// 压缩包的校验值，格式为 sha256:十六进制串
+	Checksum string `gorm:"column:checksum" form:"checksum"`
 }
 
 type CsvFile struct {
@@ -21,6 +22,8 @@ type CsvFile struct {
 
 // 国家统计局每条记录对应的发布日期与链接
 type PublishRecord struct {
+	//版本年份
+	Year string `json:"year"`
 	//发布日期
 	Date string `json:"date"`
 	//链接
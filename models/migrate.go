@@ -0,0 +1,381 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// 记录已执行迁移的表
+const migrationTableName = "schema_migrations"
+
+// 一次数据库结构变更，Up 在事务中执行(MySQL 的 DDL 会隐式提交，出错时需按 status 检查后手动处理)
+// 各步骤先检查表、列和索引是否已经存在，按 mysql 目录下的 SQL 手工建过表的数据库也可以直接执行
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+}
+
+// 一个迁移的执行情况
+type MigrationStatus struct {
+	Version int
+	Name    string
+	Applied bool
+	// 执行时间，未执行时为空
+	AppliedAt string
+}
+
+// 已执行迁移的记录
+type schemaMigration struct {
+	Version   int    `gorm:"column:version"`
+	Name      string `gorm:"column:name"`
+	AppliedAt string `gorm:"column:applied_at"`
+}
+
+func (m *schemaMigration) TableName() string {
+	return migrationTableName
+}
+
+// 各数据库建表语句的差异
+type schemaDialect struct {
+	// 自增主键
+	id string
+	// 日期时间类型
+	datetime string
+	// 建表语句末尾的表选项
+	tableOptions string
+}
+
+var schemaDialects = map[string]schemaDialect{
+	DriverMySQL: {
+		id:           "int NOT NULL AUTO_INCREMENT PRIMARY KEY",
+		datetime:     "datetime",
+		tableOptions: " ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+	},
+	DriverPostgres: {
+		id:       "SERIAL PRIMARY KEY",
+		datetime: "timestamp",
+	},
+	DriverSQLite: {
+		id:       "INTEGER PRIMARY KEY AUTOINCREMENT",
+		datetime: "datetime",
+	},
+}
+
+// 全部迁移，按版本号递增，已发布的迁移不能修改，结构变更需追加新的迁移
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create province_city_region, fetch_record, special_region, code_successor",
+		Up: func(tx *gorm.DB) error {
+			steps := []func() error{
+				func() error {
+					return createTable(tx, TableName, []string{
+						"id $ID",
+						"province_code int NOT NULL DEFAULT 0",
+						"province_name varchar(128) NOT NULL DEFAULT ''",
+						"province_name_py varchar(128) NOT NULL DEFAULT ''",
+						"city_code int NOT NULL DEFAULT 0",
+						"city_name varchar(128) NOT NULL DEFAULT ''",
+						"city_name_py varchar(128) NOT NULL DEFAULT ''",
+						"region_code int NOT NULL DEFAULT 0",
+						"region_name varchar(128) NOT NULL DEFAULT ''",
+						"region_name_py varchar(128) NOT NULL DEFAULT ''",
+						"town_code int NOT NULL DEFAULT 0",
+						"town_name varchar(128) NOT NULL DEFAULT ''",
+						"village_code bigint NOT NULL DEFAULT 0",
+						"village_name varchar(128) NOT NULL DEFAULT ''",
+						"urban_rural_code varchar(8) NOT NULL DEFAULT ''",
+						"full_code varchar(12) NOT NULL DEFAULT ''",
+						"city_code_telephone varchar(8) NOT NULL DEFAULT ''",
+						"area varchar(64) NOT NULL DEFAULT ''",
+						"retired_at varchar(36) NOT NULL DEFAULT ''",
+					})
+				},
+				func() error { return createIndex(tx, TableName, "idx_pcr_province_code", false, "province_code") },
+				func() error { return createIndex(tx, TableName, "idx_pcr_city_code", false, "city_code") },
+				func() error { return createIndex(tx, TableName, "idx_pcr_region_code", false, "region_code") },
+				func() error {
+					return createTable(tx, "fetch_record", []string{
+						"id $ID",
+						"update_at varchar(36) NOT NULL",
+						"update_time varchar(36) NULL",
+						"down_url varchar(255) NOT NULL",
+						"checksum varchar(80) NOT NULL DEFAULT ''",
+					})
+				},
+				func() error {
+					return createTable(tx, "special_region", []string{
+						"id $ID",
+						"zoning_code varchar(36) NOT NULL",
+						"city_code varchar(36) NULL",
+						"region_name varchar(100) NOT NULL",
+						"replace_id int NOT NULL",
+						"create_time $DATETIME DEFAULT CURRENT_TIMESTAMP",
+					})
+				},
+				func() error { return createIndex(tx, "special_region", "uniq_zoning_code", true, "zoning_code") },
+				func() error {
+					return createIndex(tx, "special_region", "idx_special_region_city_code", false, "city_code")
+				},
+				func() error {
+					return createTable(tx, "code_successor", []string{
+						"id $ID",
+						"old_code varchar(12) NOT NULL",
+						"new_code varchar(12) NOT NULL DEFAULT ''",
+						"change_type varchar(16) NOT NULL",
+						"effective_date varchar(36) NOT NULL",
+						"create_time $DATETIME DEFAULT CURRENT_TIMESTAMP",
+					})
+				},
+				func() error {
+					return createIndex(tx, "code_successor", "uniq_code_successor", true, "old_code", "new_code", "effective_date")
+				},
+			}
+			for _, step := range steps {
+				if err := step(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add fetch_record.checksum, widen fetch_record.down_url",
+		Up: func(tx *gorm.DB) error {
+			if err := addColumn(tx, "fetch_record", "checksum", "varchar(80) NOT NULL DEFAULT ''"); err != nil {
+				return err
+			}
+			// sqlite 不限制 varchar 长度
+			switch tx.Dialect().GetName() {
+			case DriverMySQL:
+				return tx.Exec("ALTER TABLE fetch_record MODIFY down_url varchar(255) NOT NULL").Error
+			case DriverPostgres:
+				return tx.Exec("ALTER TABLE fetch_record ALTER COLUMN down_url TYPE varchar(255)").Error
+			}
+			return nil
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add province_city_region.retired_at",
+		Up: func(tx *gorm.DB) error {
+			return addColumn(tx, TableName, "retired_at", "varchar(36) NOT NULL DEFAULT ''")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add province_city_region town, village, urban_rural_code and full_code columns",
+		Up: func(tx *gorm.DB) error {
+			// 只有省市区三级时建的表没有这些列，v1 中表已存在时不会补上
+			columns := []struct{ name, definition string }{
+				{"town_code", "int NOT NULL DEFAULT 0"},
+				{"town_name", "varchar(128) NOT NULL DEFAULT ''"},
+				{"village_code", "bigint NOT NULL DEFAULT 0"},
+				{"village_name", "varchar(128) NOT NULL DEFAULT ''"},
+				{"urban_rural_code", "varchar(8) NOT NULL DEFAULT ''"},
+				{"full_code", "varchar(12) NOT NULL DEFAULT ''"},
+			}
+			for _, column := range columns {
+				if err := addColumn(tx, TableName, column.name, column.definition); err != nil {
+					return err
+				}
+			}
+			return createIndex(tx, TableName, "idx_pcr_full_code", false, "full_code")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add code_successor.level",
+		Up: func(tx *gorm.DB) error {
+			return addColumn(tx, "code_successor", "level", "varchar(16) NOT NULL DEFAULT ''")
+		},
+	},
+}
+
+// 查看各迁移的执行情况
+func GetMigrationStatus(db *gorm.DB) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(Migrations))
+	for _, m := range Migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if record, ok := applied[m.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// 按版本顺序执行所有未执行的迁移，返回本次执行的迁移，出错时停在出错的迁移
+func Migrate(db *gorm.DB) ([]Migration, error) {
+	if _, ok := schemaDialects[db.Dialect().GetName()]; !ok {
+		return nil, fmt.Errorf("不支持的数据库 %s", db.Dialect().GetName())
+	}
+	if !db.HasTable(migrationTableName) {
+		err := db.Exec(fmt.Sprintf("CREATE TABLE %s (version int NOT NULL PRIMARY KEY, name varchar(255) NOT NULL, applied_at varchar(36) NOT NULL)", migrationTableName)).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	done := make([]Migration, 0)
+	for _, m := range Migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			record := schemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().Format("2006-01-02 15:04:05")}
+			return tx.Create(&record).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d %s error:%v", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// 已执行的迁移，记录表不存在时为空
+func appliedMigrations(db *gorm.DB) (map[int]schemaMigration, error) {
+	if !db.HasTable(migrationTableName) {
+		return map[int]schemaMigration{}, nil
+	}
+	records := make([]schemaMigration, 0)
+	if err := db.Order("version").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]schemaMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// 表不存在时建表，列定义中的 $ID、$DATETIME 按数据库替换
+func createTable(tx *gorm.DB, table string, columns []string) error {
+	if tx.HasTable(table) {
+		return nil
+	}
+	d := schemaDialects[tx.Dialect().GetName()]
+	replacer := strings.NewReplacer("$ID", d.id, "$DATETIME", d.datetime)
+	definition := replacer.Replace(strings.Join(columns, ",\n\t"))
+	return tx.Exec(fmt.Sprintf("CREATE TABLE %s (\n\t%s\n)%s", table, definition, d.tableOptions)).Error
+}
+
+// 索引不存在时创建
+// 按 mysql 目录下的 SQL 建的索引名称不同，如 ADD UNIQUE (zoning_code) 的索引名为 zoning_code，相同的列上已有索引时也不再创建
+func createIndex(tx *gorm.DB, table string, name string, unique bool, columns ...string) error {
+	if tx.Dialect().HasIndex(table, name) {
+		return nil
+	}
+	indexes, err := tableIndexes(tx, table)
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if strings.Join(index.columns, ",") == strings.Join(columns, ",") && (index.unique || !unique) {
+			return nil
+		}
+	}
+	kind := "INDEX"
+	if unique {
+		kind = "UNIQUE INDEX"
+	}
+	return tx.Exec(fmt.Sprintf("CREATE %s %s ON %s (%s)", kind, name, table, strings.Join(columns, ", "))).Error
+}
+
+// 列不存在时添加
+func addColumn(tx *gorm.DB, table string, column string, definition string) error {
+	if tx.Dialect().HasColumn(table, column) {
+		return nil
+	}
+	return tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)).Error
+}
+
+// 表中已有的一个索引
+type tableIndex struct {
+	name    string
+	unique  bool
+	columns []string
+}
+
+// 查询表中已有的索引及其各列，列按在索引中的顺序排列
+func tableIndexes(tx *gorm.DB, table string) ([]tableIndex, error) {
+	var query string
+	switch tx.Dialect().GetName() {
+	case DriverMySQL:
+		query = "SELECT index_name, non_unique = 0, column_name FROM information_schema.statistics " +
+			"WHERE table_schema = DATABASE() AND table_name = ? ORDER BY index_name, seq_in_index"
+	case DriverPostgres:
+		query = "SELECT i.relname, x.indisunique, a.attname FROM pg_index x " +
+			"JOIN pg_class t ON t.oid = x.indrelid JOIN pg_class i ON i.oid = x.indexrelid " +
+			"JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = ANY(x.indkey) " +
+			"WHERE t.relname = ? AND pg_table_is_visible(t.oid) ORDER BY i.relname, array_position(x.indkey::smallint[], a.attnum)"
+	case DriverSQLite:
+		return sqliteIndexes(tx, table)
+	default:
+		return nil, fmt.Errorf("不支持的数据库 %s", tx.Dialect().GetName())
+	}
+	rows, err := tx.Raw(query, table).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	indexes := make([]tableIndex, 0)
+	for rows.Next() {
+		var index tableIndex
+		var column string
+		if err := rows.Scan(&index.name, &index.unique, &column); err != nil {
+			return nil, err
+		}
+		if n := len(indexes); n > 0 && indexes[n-1].name == index.name {
+			indexes[n-1].columns = append(indexes[n-1].columns, column)
+			continue
+		}
+		index.columns = []string{column}
+		indexes = append(indexes, index)
+	}
+	return indexes, rows.Err()
+}
+
+// sqlite 通过 PRAGMA index_list、index_info 查询索引
+func sqliteIndexes(tx *gorm.DB, table string) ([]tableIndex, error) {
+	list := make([]struct {
+		Name   string `gorm:"column:name"`
+		Unique bool   `gorm:"column:unique"`
+	}, 0)
+	if err := tx.Raw(fmt.Sprintf("PRAGMA index_list(%s)", table)).Scan(&list).Error; err != nil {
+		return nil, err
+	}
+	indexes := make([]tableIndex, 0, len(list))
+	for _, item := range list {
+		info := make([]struct {
+			Seqno int    `gorm:"column:seqno"`
+			Name  string `gorm:"column:name"`
+		}, 0)
+		if err := tx.Raw(fmt.Sprintf("PRAGMA index_info(%s)", item.Name)).Scan(&info).Error; err != nil {
+			return nil, err
+		}
+		index := tableIndex{name: item.Name, unique: item.Unique}
+		for _, column := range info {
+			index.columns = append(index.columns, column.Name)
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
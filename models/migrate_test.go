@@ -0,0 +1,230 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestMigrateTwice(t *testing.T) {
+	db, err := Open("sqlite3://:memory:")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	statuses, err := GetMigrationStatus(db)
+	if err != nil {
+		t.Fatalf("GetMigrationStatus() before Migrate error = %v", err)
+	}
+	for _, status := range statuses {
+		if status.Applied {
+			t.Errorf("migration %d applied before Migrate", status.Version)
+		}
+	}
+
+	done, err := Migrate(db)
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if len(done) != len(Migrations) {
+		t.Errorf("Migrate() applied %d migrations, want %d", len(done), len(Migrations))
+	}
+	first, err := GetMigrationStatus(db)
+	if err != nil {
+		t.Fatalf("GetMigrationStatus() error = %v", err)
+	}
+	for i, status := range first {
+		if !status.Applied || status.AppliedAt == "" || status.Version != Migrations[i].Version {
+			t.Errorf("status = %+v", status)
+		}
+	}
+
+	// 再次执行时没有需要执行的迁移，执行记录不变
+	done, err = Migrate(db)
+	if err != nil {
+		t.Fatalf("second Migrate() error = %v", err)
+	}
+	if len(done) != 0 {
+		t.Errorf("second Migrate() applied %v", done)
+	}
+	second, err := GetMigrationStatus(db)
+	if err != nil {
+		t.Fatalf("second GetMigrationStatus() error = %v", err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("status changed:\n%+v\n%+v", first, second)
+	}
+
+	// 已执行的迁移重新执行时各步骤都跳过
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, m := range Migrations {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("re-running migrations error = %v", err)
+	}
+}
+
+// 只有省市区三级时按 mysql 目录下的 SQL 手工建的表
+var legacySchema = []string{
+	`CREATE TABLE province_city_region (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		province_code int NOT NULL DEFAULT 0,
+		province_name varchar(128) NOT NULL DEFAULT '',
+		province_name_py varchar(128) NOT NULL DEFAULT '',
+		city_code int NOT NULL DEFAULT 0,
+		city_name varchar(128) NOT NULL DEFAULT '',
+		city_name_py varchar(128) NOT NULL DEFAULT '',
+		region_code int NOT NULL DEFAULT 0,
+		region_name varchar(128) NOT NULL DEFAULT '',
+		region_name_py varchar(128) NOT NULL DEFAULT '',
+		city_code_telephone varchar(8) NOT NULL DEFAULT '',
+		area varchar(64) NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE fetch_record (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		update_at varchar(36) NOT NULL,
+		update_time varchar(36) NULL,
+		down_url varchar(100) NOT NULL
+	)`,
+	`CREATE TABLE special_region (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		zoning_code varchar(36) NOT NULL,
+		city_code varchar(36) NULL,
+		region_name varchar(100) NOT NULL,
+		replace_id int NOT NULL,
+		create_time datetime DEFAULT CURRENT_TIMESTAMP
+	)`,
+	// MySQL 中 ADD UNIQUE (zoning_code) 的索引名为 zoning_code
+	`CREATE UNIQUE INDEX zoning_code ON special_region (zoning_code)`,
+	`CREATE INDEX index_name ON special_region (city_code)`,
+	`CREATE TABLE code_successor (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		old_code varchar(12) NOT NULL,
+		new_code varchar(12) NOT NULL DEFAULT '',
+		change_type varchar(16) NOT NULL,
+		effective_date varchar(36) NOT NULL,
+		create_time datetime DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE UNIQUE INDEX old_code ON code_successor (old_code, new_code, effective_date)`,
+	`INSERT INTO province_city_region (province_code, province_name, province_name_py, city_code, city_name, city_name_py, region_code, region_name, region_name_py, city_code_telephone)
+		VALUES (37, '山东省', 'shandong', 3701, '济南市', 'jinan', 370102, '历下区', 'lixia', '0531')`,
+	`INSERT INTO fetch_record (update_at, update_time, down_url) VALUES ('2019-10-31', '2020-01-01 00:00:00', 'https://example.com/china_area.zip')`,
+}
+
+func TestMigrateLegacyTables(t *testing.T) {
+	db, err := Open("sqlite3://:memory:")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+	for _, statement := range legacySchema {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := Migrate(db); err != nil {
+			t.Fatalf("Migrate() #%d error = %v", i+1, err)
+		}
+	}
+
+	wantColumns := map[string][]string{
+		TableName:        {"town_code", "town_name", "village_code", "village_name", "urban_rural_code", "full_code", "retired_at"},
+		"fetch_record":   {"checksum"},
+		"special_region": {"zoning_code", "city_code"},
+		"code_successor": {"level", "old_code", "new_code", "effective_date"},
+	}
+	for table, columns := range wantColumns {
+		for _, column := range columns {
+			if !db.Dialect().HasColumn(table, column) {
+				t.Errorf("%s.%s is missing", table, column)
+			}
+		}
+	}
+
+	// 已有的索引列相同时不重复创建
+	wantIndexes := map[string][]tableIndex{
+		"special_region": {
+			{name: "index_name", columns: []string{"city_code"}},
+			{name: "zoning_code", unique: true, columns: []string{"zoning_code"}},
+		},
+		"code_successor": {
+			{name: "old_code", unique: true, columns: []string{"old_code", "new_code", "effective_date"}},
+		},
+	}
+	for table, want := range wantIndexes {
+		got, err := tableIndexes(db, table)
+		if err != nil {
+			t.Fatalf("tableIndexes(%s) error = %v", table, err)
+		}
+		if !sameIndexes(got, want) {
+			t.Errorf("%s indexes = %+v, want %+v", table, got, want)
+		}
+	}
+
+	// 旧数据在迁移后可以按现行区划查询，新增的列为默认值
+	list := ProvinceCityRegionModelList{}
+	if err := list.GetAllOrderAsc(db); err != nil {
+		t.Fatalf("GetAllOrderAsc() error = %v", err)
+	}
+	if len(list) != 1 || list[0].RegionName != "历下区" || list[0].RegionNamePy != "lixia" || list[0].FullCode != "" || list[0].TownCode != 0 {
+		t.Errorf("GetAllOrderAsc() = %+v", list)
+	}
+	record := FetchRecord{}
+	if err := record.GetNewestFetchRecord(db); err != nil || record.UpdateAt != "2019-10-31" || record.Checksum != "" {
+		t.Errorf("GetNewestFetchRecord() = %+v, %v", record, err)
+	}
+}
+
+func TestMigrateCreatesIndexes(t *testing.T) {
+	db := openTestDB(t)
+	want := map[string][]tableIndex{
+		TableName: {
+			{name: "idx_pcr_city_code", columns: []string{"city_code"}},
+			{name: "idx_pcr_full_code", columns: []string{"full_code"}},
+			{name: "idx_pcr_province_code", columns: []string{"province_code"}},
+			{name: "idx_pcr_region_code", columns: []string{"region_code"}},
+		},
+		"special_region": {
+			{name: "idx_special_region_city_code", columns: []string{"city_code"}},
+			{name: "uniq_zoning_code", unique: true, columns: []string{"zoning_code"}},
+		},
+		"code_successor": {
+			{name: "uniq_code_successor", unique: true, columns: []string{"old_code", "new_code", "effective_date"}},
+		},
+	}
+	for table, wantIndexes := range want {
+		got, err := tableIndexes(db, table)
+		if err != nil {
+			t.Fatalf("tableIndexes(%s) error = %v", table, err)
+		}
+		if !sameIndexes(got, wantIndexes) {
+			t.Errorf("%s indexes = %+v, want %+v", table, got, wantIndexes)
+		}
+	}
+}
+
+// 不考虑顺序比较索引
+func sameIndexes(got []tableIndex, want []tableIndex) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	byName := make(map[string]tableIndex, len(got))
+	for _, index := range got {
+		byName[index.name] = index
+	}
+	for _, index := range want {
+		if !reflect.DeepEqual(byName[index.name], index) {
+			return false
+		}
+	}
+	return true
+}
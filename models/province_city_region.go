@@ -1,71 +1,112 @@
 package models
 
-// 此处自行导入 gorm
-import "gorm"
+import (
+	"errors"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
 
 const TableName = "province_city_region"
 
+// 批量写入时每条语句处理的行数，每行 18 个占位符，不超过 sqlite 旧版本单条语句 999 个的限制
+const batchSize = 50
+
 type ProvinceCityRegionModel struct {
-	ID                int    `gorm:"column:id" sql:"type:int(11)" json:"id"`
-	ProvinceCode      int    `gorm:"column:province_code" sql:"type:int(11)" json:"province_code"`
-	ProvinceName      string `gorm:"column:province_name" sql:"type:varchar(128)" json:"province_name"`
-	ProvinceNamePy    string `gorm:"column:province_name_py" sql:"type:varchar(128)" json:"province_name_py"`
-	CityCode          int    `gorm:"column:city_code" sql:"type:int(11)" json:"city_code"`
-	CityName          string `gorm:"column:city_name" sql:"type:varchar(128)" json:"city_name"`
-	CityNamePy        string `gorm:"column:city_name_py" sql:"type:varchar(128)" json:"city_name_py"`
-	RegionCode        int    `gorm:"column:region_code" sql:"type:int(11)" json:"region_code"`
-	RegionName        string `gorm:"column:region_name" sql:"type:varchar(128)" json:"region_name"`
-	RegionNamePy      string `gorm:"column:region_name_py" sql:"type:varchar(128)" json:"region_name_py"`
+	ID             int    `gorm:"column:id" sql:"type:int(11)" json:"id"`
+	ProvinceCode   int    `gorm:"column:province_code" sql:"type:int(11)" json:"province_code"`
+	ProvinceName   string `gorm:"column:province_name" sql:"type:varchar(128)" json:"province_name"`
+	ProvinceNamePy string `gorm:"column:province_name_py" sql:"type:varchar(128)" json:"province_name_py"`
+	CityCode       int    `gorm:"column:city_code" sql:"type:int(11)" json:"city_code"`
+	CityName       string `gorm:"column:city_name" sql:"type:varchar(128)" json:"city_name"`
+	CityNamePy     string `gorm:"column:city_name_py" sql:"type:varchar(128)" json:"city_name_py"`
+	RegionCode     int    `gorm:"column:region_code" sql:"type:int(11)" json:"region_code"`
+	RegionName     string `gorm:"column:region_name" sql:"type:varchar(128)" json:"region_name"`
+	RegionNamePy   string `gorm:"column:region_name_py" sql:"type:varchar(128)" json:"region_name_py"`
+	TownCode       int    `gorm:"column:town_code" sql:"type:int(11)" json:"town_code"`
+	TownName       string `gorm:"column:town_name" sql:"type:varchar(128)" json:"town_name"`
+	VillageCode    int    `gorm:"column:village_code" sql:"type:bigint(20)" json:"village_code"`
+	VillageName    string `gorm:"column:village_name" sql:"type:varchar(128)" json:"village_name"`
+	UrbanRuralCode string `gorm:"column:urban_rural_code" sql:"type:varchar(8)" json:"urban_rural_code"`
+	// 本行最下一级的12位统计用区划代码
+	FullCode          string `gorm:"column:full_code" sql:"type:varchar(12)" json:"full_code"`
 	CityCodeTelephone string `gorm:"column:city_code_telephone" sql:"type:varchar(8)" json:"city_code_telephone"`
 	Area              string `gorm:"column:area" sql:"type:varchar(64)" json:"area"`
+	// 从哪个发布版本起不再出现，为空时为现行区划；撤销的区划保留记录，不删除
+	RetiredAt string `gorm:"column:retired_at" sql:"type:varchar(36)" json:"retired_at"`
 }
 
-type Province struct {
-	Code   int    `json:"code"`
-	Name   string `json:"name"`
-	Link   string `json:"-"`
-	Cities []City `json:"cities"`
-}
+type ProvinceCityRegionModelList []ProvinceCityRegionModel
 
-type City struct {
-	Code     int      `json:"code"`
-	Name     string   `json:"name"`
-	Link     string   `json:"-"`
-	Counties []County `json:"counties"`
+// 获取所有现行区划，不包含已撤销的数据
+func (list *ProvinceCityRegionModelList) GetAllOrderAsc(db *gorm.DB) error {
+	return db.Table(TableName).Where("retired_at = ?", "").Order("province_code, city_code, region_code, town_code, village_code").Find(list).Error
 }
 
-type County struct {
-	Code int    `json:"code"`
-	Name string `json:"name"`
-	Link string `json:"-"`
+// 获取所有数据，包含已撤销的
+func (list *ProvinceCityRegionModelList) GetAll(db *gorm.DB) error {
+	return db.Table(TableName).Order("id").Find(list).Error
 }
 
-type ProvinceCityRegionModelList []ProvinceCityRegionModel
-
-type DB struct {
-	*gorm.DB
+func (p *ProvinceCityRegionModel) Create(db *gorm.DB) error {
+	return db.Table(TableName).Create(p).Error
 }
 
-var db *DB
-
-// 获取所有的不重复的省code
-func (list *ProvinceCityRegionModelList) GetAllProvince() {
-	db.Table(TableName).Select("distinct(province_code)").Find(&list)
+// 按批写入，每批一条多行 INSERT 语句，id 由数据库生成
+func (list ProvinceCityRegionModelList) CreateInBatches(db *gorm.DB) error {
+	columns := []string{
+		"province_code", "province_name", "province_name_py", "city_code", "city_name", "city_name_py",
+		"region_code", "region_name", "region_name_py", "town_code", "town_name", "village_code", "village_name",
+		"urban_rural_code", "full_code", "city_code_telephone", "area", "retired_at",
+	}
+	placeholder := "(" + strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",") + ")"
+	for start := 0; start < len(list); start += batchSize {
+		batch := list[start:min(start+batchSize, len(list))]
+		values := make([]string, 0, len(batch))
+		args := make([]interface{}, 0, len(batch)*len(columns))
+		for _, p := range batch {
+			values = append(values, placeholder)
+			args = append(args, p.ProvinceCode, p.ProvinceName, p.ProvinceNamePy, p.CityCode, p.CityName, p.CityNamePy,
+				p.RegionCode, p.RegionName, p.RegionNamePy, p.TownCode, p.TownName, p.VillageCode, p.VillageName,
+				p.UrbanRuralCode, p.FullCode, p.CityCodeTelephone, p.Area, p.RetiredAt)
+		}
+		sql := "INSERT INTO " + TableName + " (" + strings.Join(columns, ", ") + ") VALUES " + strings.Join(values, ", ")
+		if err := db.Exec(sql, args...).Error; err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (list *ProvinceCityRegionModelList) GetAllOrderAsc() error {
-	err := db.Table(TABLE_NAME).Order("province_code, city_code, region_code").Find(list).Error
-	return err
+// 把列表中的行标记为从 retiredAt 版本起撤销，按 id 分批更新
+func (list ProvinceCityRegionModelList) Retire(db *gorm.DB, retiredAt string) error {
+	ids := make([]int, 0, len(list))
+	for _, p := range list {
+		if p.ID == 0 {
+			return errors.New("id is empty")
+		}
+		ids = append(ids, p.ID)
+	}
+	for start := 0; start < len(ids); start += batchSize {
+		batch := ids[start:min(start+batchSize, len(ids))]
+		if err := db.Table(TableName).Where("id IN (?)", batch).Updates(map[string]interface{}{"retired_at": retiredAt}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// 根据省code获取该省下属的所有不重复的市
-func (list *ProvinceCityRegionModelList) GetCityListOfSingleProvince(provinceCode int) {
-	db.Table(TableName).Select("distinct(city_code)").Where("province_code = ?", provinceCode).Where("city_code != ?", 0).Find(&list)
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
 }
 
-// 判断该市下面是否有区级数据
-func (p *ProvinceCityRegionModel) HasCounty(cityCode int) bool {
-	var count int
-	db.Table(TableName).Where("city_code = ?", cityCode).Where("region_code != ?", 0).Count(&count)
-	return count >= 1
+// 按 id 更新指定的列，id 为空时不更新，避免条件为空时更新整张表
+func (p *ProvinceCityRegionModel) UpdateColumns(db *gorm.DB, columns map[string]interface{}) error {
+	if p.ID == 0 {
+		return errors.New("id is empty")
+	}
+	return db.Table(TableName).Where("id = ?", p.ID).Updates(columns).Error
 }
@@ -0,0 +1,33 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+// 东莞市、中山市和儋州市等不设区县的城市，其下属镇的合成区县代码分配记录
+// create_time 由数据库默认值填充
+type SpecialRegion struct {
+	Id int `gorm:"column:id" form:"id"`
+	// 镇的12位统计用区划代码
+	ZoningCode string `gorm:"column:zoning_code" form:"zoning_code"`
+	// 所属城市的4位代码
+	CityCode string `gorm:"column:city_code" form:"city_code"`
+	// 镇名称
+	RegionName string `gorm:"column:region_name" form:"region_name"`
+	// 合成区县代码的末尾2位，合成代码为 城市代码 + replace_id
+	ReplaceId int `gorm:"column:replace_id" form:"replace_id"`
+}
+
+type SpecialRegionList []SpecialRegion
+
+func (s *SpecialRegion) TableName() string {
+	return "special_region"
+}
+
+func (s *SpecialRegion) Create(db *gorm.DB) error {
+	return db.Table(s.TableName()).Create(s).Error
+}
+
+// 获取某个城市下已经分配过的所有镇
+func (list *SpecialRegionList) GetByCityCode(db *gorm.DB, cityCode string) error {
+	var s SpecialRegion
+	return db.Table(s.TableName()).Where("city_code = ?", cityCode).Order("replace_id").Find(list).Error
+}
@@ -0,0 +1,14 @@
+create table code_successor
+(
+    id             int(10) auto_increment comment '自增主键'
+        primary key,
+    level          varchar(16) not null default '' comment '区划层级 province/city/county/town',
+    old_code       varchar(12) not null comment '旧的12位区划代码，新增时为空',
+    new_code       varchar(12) not null default '' comment '新的12位区划代码，撤销且无继承时为空',
+    change_type    varchar(16) not null comment '变更类型 recoded/reparented/abolished/added',
+    effective_date varchar(36) not null comment '生效的发布版本',
+    create_time    datetime DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间'
+)ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 ROW_FORMAT=COMPACT COMMENT='区划代码新旧对照表';
+
+ALTER TABLE `code_successor`
+    ADD UNIQUE (`old_code`, `new_code`, `effective_date`);
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// 抓取进度事件类型
+const (
+	// 一个页面抓取并解析成功
+	ProgressPageFetched = "page_fetched"
+	// 一个页面多次重试后仍然失败
+	ProgressPageFailed = "page_failed"
+	// 一个省份及其下属页面全部抓取完成
+	ProgressProvinceDone = "province_done"
+	// 整个抓取过程结束
+	ProgressFinished = "finished"
+)
+
+// 抓取进度事件，计数均为本次抓取开始以来的累计值，从断点恢复的页面不计入
+type ProgressEvent struct {
+	Type string `json:"type"`
+	// 页面层级，与 CrawlFailure.Level 相同
+	Level string `json:"level,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Url   string `json:"url,omitempty"`
+	Error string `json:"error,omitempty"`
+
+	PagesFetched   int `json:"pages_fetched"`
+	PagesFailed    int `json:"pages_failed"`
+	ProvincesDone  int `json:"provinces_done"`
+	ProvincesTotal int `json:"provinces_total"`
+	// 已用时间和按已完成省份数估算的剩余时间，还没有省份完成时 ETA 为 0
+	Elapsed time.Duration `json:"elapsed"`
+	ETA     time.Duration `json:"eta"`
+}
+
+// 进度回调，在抓取的各个 goroutine 中串行调用，需要尽快返回
+type ProgressFunc func(event ProgressEvent)
+
+// 把进度事件逐行写为 JSON，供监控系统采集
+func JSONProgressWriter(w io.Writer) ProgressFunc {
+	encoder := json.NewEncoder(w)
+	return func(event ProgressEvent) {
+		encoder.Encode(event)
+	}
+}
+
+// 一次抓取的进度统计，report 为空时只统计不回调
+type crawlProgress struct {
+	mu             sync.Mutex
+	report         ProgressFunc
+	start          time.Time
+	pagesFetched   int
+	pagesFailed    int
+	provincesDone  int
+	provincesTotal int
+}
+
+func newCrawlProgress(report ProgressFunc, provincesTotal int) *crawlProgress {
+	return &crawlProgress{
+		report:         report,
+		start:          time.Now(),
+		provincesTotal: provincesTotal,
+	}
+}
+
+func (p *crawlProgress) pageFetched(level, name, url string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pagesFetched++
+	metricPagesFetched.WithLabelValues(level).Inc()
+	p.emit(ProgressEvent{Type: ProgressPageFetched, Level: level, Name: name, Url: url})
+}
+
+func (p *crawlProgress) pageFailed(level, name, url string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pagesFailed++
+	metricPagesFailed.WithLabelValues(level).Inc()
+	p.emit(ProgressEvent{Type: ProgressPageFailed, Level: level, Name: name, Url: url, Error: err.Error()})
+}
+
+func (p *crawlProgress) provinceDone(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.provincesDone++
+	p.emit(ProgressEvent{Type: ProgressProvinceDone, Level: "province", Name: name})
+}
+
+func (p *crawlProgress) finished(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	event := ProgressEvent{Type: ProgressFinished}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	p.emit(event)
+}
+
+// 填充累计值后回调，调用方需持有锁
+func (p *crawlProgress) emit(event ProgressEvent) {
+	if p.report == nil {
+		return
+	}
+	event.PagesFetched = p.pagesFetched
+	event.PagesFailed = p.pagesFailed
+	event.ProvincesDone = p.provincesDone
+	event.ProvincesTotal = p.provincesTotal
+	event.Elapsed = time.Since(p.start)
+	if p.provincesDone > 0 && p.provincesDone < p.provincesTotal {
+		perProvince := event.Elapsed / time.Duration(p.provincesDone)
+		event.ETA = perProvince * time.Duration(p.provincesTotal-p.provincesDone)
+	}
+	p.report(event)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"China_area_data/clog"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// 列出国家统计局所有的发布记录
+func (s *CrawlerSession) ListPublishRecords(ctx context.Context) {
+	publishRecords, err := s.GetPublishRecord(ctx)
+	if err != nil {
+		clog.Logger.Error("GetPublishRecord err: %v", err)
+		return
+	}
+	for _, record := range publishRecords {
+		fmt.Printf("%s\t%s\t%s\n", record.Year, record.Date, record.Link)
+	}
+}
+
+// 按版本年份(如 2019)或发布日期(如 2020-11-06)筛选发布记录，保持原有顺序
+// 任一条件没有匹配到记录时返回错误
+func FilterPublishRecords(records []PublishRecord, selectors []string) ([]PublishRecord, error) {
+	picked := make(map[string]bool)
+	for _, selector := range selectors {
+		selector = strings.TrimSpace(selector)
+		if selector == "" {
+			continue
+		}
+		matched := false
+		for _, record := range records {
+			if record.Year != selector && record.Date != selector {
+				continue
+			}
+			matched = true
+			picked[record.Link] = true
+		}
+		if !matched {
+			return nil, fmt.Errorf("没有找到 %s 的发布记录", selector)
+		}
+	}
+	// 按发布记录页面上的顺序返回
+	ordered := make([]PublishRecord, 0, len(picked))
+	for _, record := range records {
+		if picked[record.Link] {
+			ordered = append(ordered, record)
+		}
+	}
+	return ordered, nil
+}
+
+// 抓取指定的一个或多个历史版本，每个版本写入 中国省市区数据_年份 文件
+// 某个版本失败不影响其它版本，返回最后一个失败版本的错误
+func (s *CrawlerSession) GetChinaAreaDataOfReleases(ctx context.Context, selectors []string) (err error) {
+	publishRecords, err := s.GetPublishRecord(ctx)
+	if err != nil {
+		clog.Logger.Error("GetPublishRecord err: %v", err)
+		return err
+	}
+	releases, err := FilterPublishRecords(publishRecords, selectors)
+	if err != nil {
+		clog.Logger.Error("FilterPublishRecords err: %v", err)
+		return err
+	}
+	for _, record := range releases {
+		// 被取消或超时后不再抓取剩余版本
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		clog.Logger.With(clog.Fields{"year": record.Year, "date": record.Date}).Info("crawl release")
+		if crawlErr := s.CrawlRelease(ctx, record, true); crawlErr != nil {
+			clog.Logger.Error("crawl release %s err: %v", record.Year, crawlErr)
+			err = crawlErr
+		}
+	}
+	return err
+}
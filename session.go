@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocolly/colly"
+	"github.com/gocolly/colly/extensions"
+)
+
+// 抓取会话的网络配置
+type SessionOptions struct {
+	// 代理地址，如 http://127.0.0.1:8080，为空时不使用代理
+	Proxy string
+	// 单个请求的超时时间，为 0 时使用 colly 默认的 10 秒
+	Timeout time.Duration
+	// 跳过 https 证书校验
+	InsecureSkipVerify bool
+	// 额外信任的 CA 证书文件(PEM)
+	CAFile string
+	// 每个请求附带的请求头，设置 User-Agent 时不再使用随机 UA
+	Headers map[string]string
+	// 抓取进度回调，为空时不回调
+	Progress ProgressFunc
+}
+
+// 一次抓取会话，持有配置好的采集器，所有页面共用同一个 http 后端，限速规则对整个会话统一生效
+type CrawlerSession struct {
+	config   CrawlerConfig
+	options  CrawlOptions
+	headers  map[string]string
+	progress ProgressFunc
+	base     *colly.Collector
+	// 所有请求都经过这一层，用于随 context 取消正在进行的请求
+	transport *contextTransport
+
+	cacheMu sync.Mutex
+	// 当前抓取版本的发布日期，用作缓存子目录名和断点文件名后缀，由 CrawlRelease 在抓取前设置
+	cacheNamespace string
+}
+
+// 创建抓取会话，config 为目标网站配置，options 为并发、重试和缓存配置，sessionOptions 为网络配置
+func NewCrawlerSession(config CrawlerConfig, options CrawlOptions, sessionOptions SessionOptions) (*CrawlerSession, error) {
+	transport := &http.Transport{
+		// 禁用 keep-alive
+		DisableKeepAlives: true,
+	}
+	if sessionOptions.Proxy != "" {
+		proxyUrl, err := url.Parse(sessionOptions.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy %s error:%v", sessionOptions.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+	if sessionOptions.InsecureSkipVerify || sessionOptions.CAFile != "" {
+		tlsConfig := &tls.Config{InsecureSkipVerify: sessionOptions.InsecureSkipVerify}
+		if sessionOptions.CAFile != "" {
+			pem, err := ioutil.ReadFile(sessionOptions.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("%s 中没有有效的证书", sessionOptions.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	c := colly.NewCollector()
+	// 设置gbk解码，防止乱码，config.Charset 不为空时以配置为准
+	c.DetectCharset = true
+	// 各页面之间相互独立，允许重复访问同一链接
+	c.AllowURLRevisit = true
+	if sessionOptions.Timeout > 0 {
+		c.SetRequestTimeout(sessionOptions.Timeout)
+	}
+	contexts := &contextTransport{base: transport}
+	c.WithTransport(contexts)
+	parallelism := options.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	delay, randomDelay := options.Delay, options.RandomDelay
+	// colly 命中缓存时不会发出请求，只有缓存未命中时才会走到 http 传输层
+	if options.Offline {
+		contexts.base = offlineTransport{}
+		delay, randomDelay = 0, 0
+	}
+	c.Limit(&colly.LimitRule{
+		DomainGlob:  "*",
+		Parallelism: parallelism,
+		Delay:       delay,
+		RandomDelay: randomDelay,
+	})
+	return &CrawlerSession{
+		config:    config,
+		options:   options,
+		headers:   sessionOptions.Headers,
+		progress:  sessionOptions.Progress,
+		base:      c,
+		transport: contexts,
+	}, nil
+}
+
+// 返回一个采集器，共用会话的 http 后端和限速规则，采集器用完后需调用 release
+// ctx 结束后尚未发出的请求直接放弃，已经发出的请求随之取消
+func (s *CrawlerSession) newCollector(ctx context.Context) (*colly.Collector, func()) {
+	// Clone 不会复制回调，随机 UA 等需要每个采集器单独注册
+	c := s.base.Clone()
+	c.CacheDir = s.releaseCacheDir()
+	id, release := s.transport.register(ctx)
+	c.OnRequest(func(request *colly.Request) {
+		if ctx.Err() != nil {
+			request.Abort()
+			return
+		}
+		if id != "" {
+			request.Headers.Set(contextHeader, id)
+		}
+		// colly 在 OnRequest 之后才读取缓存，缓存文件存在即为命中
+		if c.CacheDir != "" {
+			if _, err := os.Stat(cacheFilePath(c.CacheDir, request.URL.String())); err == nil {
+				metricCacheRequests.WithLabelValues("hit").Inc()
+			} else {
+				metricCacheRequests.WithLabelValues("miss").Inc()
+			}
+		}
+	})
+	extensions.RandomUserAgent(c)
+	// 在随机 UA 之后注册，自定义的 User-Agent 优先
+	if len(s.headers) > 0 {
+		c.OnRequest(func(request *colly.Request) {
+			for key, value := range s.headers {
+				request.Headers.Set(key, value)
+			}
+		})
+	}
+	// 配置了页面编码时按配置解码，否则由 DetectCharset 自动检测
+	if charset := s.config.Charset; charset != "" {
+		c.OnRequest(func(request *colly.Request) {
+			request.ResponseCharacterEncoding = charset
+		})
+	}
+	// 命中缓存时同样会回调，用于记录缓存目录中有哪些链接
+	c.OnResponse(func(response *colly.Response) {
+		if c.CacheDir != "" {
+			recordCachedURL(c.CacheDir, response.Request.URL.String())
+		}
+	})
+	return c, release
+}
+
+func (s *CrawlerSession) setCacheNamespace(namespace string) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.cacheNamespace = namespace
+}
+
+// 当前版本页面的缓存目录，没有设置版本时使用缓存根目录，未开启缓存时为空
+func (s *CrawlerSession) releaseCacheDir() string {
+	if s.options.CacheDir == "" {
+		return ""
+	}
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	return filepath.Join(s.options.CacheDir, s.cacheNamespace)
+}
+
+// 当前版本的断点文件，与缓存目录一样按发布日期区分，如 抓取断点_2020-11-06，未开启断点时为空
+func (s *CrawlerSession) releaseCheckpointFile() string {
+	if s.options.CheckpointFile == "" {
+		return ""
+	}
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	if s.cacheNamespace == "" {
+		return s.options.CheckpointFile
+	}
+	return s.options.CheckpointFile + "_" + s.cacheNamespace
+}
+
+// 请求所属 context 的编号，colly 发出的 http.Request 不带 context，借助请求头传给传输层
+const contextHeader = "X-Crawl-Context"
+
+// 按请求头中的编号为请求绑定 context，context 结束时正在进行的请求随之取消
+type contextTransport struct {
+	// 原子操作的字段放在最前面，保证32位平台上的对齐
+	nextId   uint64
+	base     http.RoundTripper
+	contexts sync.Map
+}
+
+// 登记一个 context，返回写入请求头的编号，不会结束的 context 不需要登记
+func (t *contextTransport) register(ctx context.Context) (id string, release func()) {
+	if ctx.Done() == nil {
+		return "", func() {}
+	}
+	id = strconv.FormatUint(atomic.AddUint64(&t.nextId, 1), 10)
+	t.contexts.Store(id, ctx)
+	return id, func() {
+		t.contexts.Delete(id)
+	}
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if id := req.Header.Get(contextHeader); id != "" {
+		ctx := req.Context()
+		if value, ok := t.contexts.Load(id); ok {
+			ctx = value.(context.Context)
+		}
+		// RoundTrip 不能修改传入的请求，复制后再去掉编号
+		req = req.Clone(ctx)
+		req.Header.Del(contextHeader)
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	metricHttpDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		metricHttpResponses.WithLabelValues("error").Inc()
+	} else {
+		metricHttpResponses.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return resp, err
+}
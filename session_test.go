@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 一直等到客户端断开的页面，started 在第一次收到请求时关闭，cancelled 在请求被取消时关闭
+func blockingHandler(started chan<- struct{}, cancelled chan<- struct{}) http.HandlerFunc {
+	var startOnce, cancelOnce sync.Once
+	return func(w http.ResponseWriter, r *http.Request) {
+		startOnce.Do(func() { close(started) })
+		select {
+		case <-r.Context().Done():
+			cancelOnce.Do(func() { close(cancelled) })
+		case <-time.After(3 * time.Second):
+		}
+	}
+}
+
+func TestContextTransport(t *testing.T) {
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	block := blockingHandler(started, cancelled)
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(contextHeader)
+		block(w, r)
+	}))
+	defer server.Close()
+
+	transport := &contextTransport{base: &http.Transport{DisableKeepAlives: true}}
+	ctx, cancel := context.WithCancel(context.Background())
+	id, release := transport.register(ctx)
+	defer release()
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(contextHeader, id)
+	go func() {
+		<-started
+		cancel()
+	}()
+	start := time.Now()
+	if _, err := transport.RoundTrip(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("RoundTrip() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("RoundTrip() returned after %v", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("request was not cancelled")
+	}
+	if header != "" {
+		t.Errorf("request header %s = %q, want removed", contextHeader, header)
+	}
+	// 传入的请求不被修改
+	if req.Header.Get(contextHeader) != id {
+		t.Errorf("RoundTrip() modified the request header")
+	}
+
+	// 不会结束的 context 不需要登记
+	if id, _ := transport.register(context.Background()); id != "" {
+		t.Errorf("register(context.Background()) = %q, want empty", id)
+	}
+}
+
+// 抓取过程中取消，正在进行的请求随之中断，尚未发出的请求不再发出
+func TestGetProvinceUrlAndDataCancel(t *testing.T) {
+	site := newStatsSite(t)
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	site.override("/2020/37/3701.html", blockingHandler(started, cancelled))
+	session := newTestSession(t, site, func(options *CrawlOptions) {
+		options.Parallelism = 1
+		options.MaxAttempts = 3
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err := session.GetProvinceUrlAndData(ctx, site.URL+"/2020/")
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("GetProvinceUrlAndData() returned %v after cancel", elapsed)
+	}
+	var partialErr *PartialCrawlError
+	if !errors.As(err, &partialErr) || partialErr.Cause != context.Canceled || !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetProvinceUrlAndData() error = %v, want *PartialCrawlError caused by %v", err, context.Canceled)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Errorf("request to /2020/37/3701.html was not cancelled")
+	}
+	// 取消后不再重试，也不再抓取下级页面
+	if n := site.requestCount("/2020/37/3701.html"); n != 1 {
+		t.Errorf("/2020/37/3701.html requested %d times, want 1", n)
+	}
+	for _, path := range site.takeRequests() {
+		if strings.HasPrefix(path, "/2020/37/01/") {
+			t.Errorf("requested %s after cancel", path)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"China_area_data/models"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strconv"
+)
+
+// 为不设区县的城市下属的镇分配稳定的6位合成区县代码：城市4位代码 + special_region.replace_id
+// 已分配过的镇按 zoning_code 沿用原来的代码，新出现的镇在该市已有最大 replace_id 的基础上递增
+// db 为空时不读写 special_region，按镇在页面中的顺序从 1 开始分配，只保证同一次抓取中代码不重复，新增或撤销镇后代码可能变化
+func AssignSpecialRegionCodes(db *gorm.DB, provinces []Province) error {
+	for i := range provinces {
+		for j := range provinces[i].Cities {
+			city := &provinces[i].Cities[j]
+			if !city.TownAsCounty {
+				continue
+			}
+			if err := assignCityRegionCodes(db, city); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func assignCityRegionCodes(db *gorm.DB, city *City) error {
+	cityCode := strconv.Itoa(city.Code)
+	assigned := models.SpecialRegionList{}
+	if db != nil {
+		if err := assigned.GetByCityCode(db, cityCode); err != nil {
+			return fmt.Errorf("GetByCityCode %s error:%v", cityCode, err)
+		}
+	}
+	replaceIds := make(map[string]int, len(assigned))
+	maxReplaceId := 0
+	for _, region := range assigned {
+		replaceIds[region.ZoningCode] = region.ReplaceId
+		if region.ReplaceId > maxReplaceId {
+			maxReplaceId = region.ReplaceId
+		}
+	}
+	for k := range city.Counties {
+		town := &city.Counties[k]
+		replaceId, ok := replaceIds[town.FullCode]
+		if !ok {
+			maxReplaceId++
+			replaceId = maxReplaceId
+			// 合成代码只有末尾2位可用
+			if replaceId > 99 {
+				return fmt.Errorf("%s 下属的镇超过99个，无法分配合成区县代码", city.Name)
+			}
+			if db != nil {
+				region := models.SpecialRegion{
+					ZoningCode: town.FullCode,
+					CityCode:   cityCode,
+					RegionName: town.Name,
+					ReplaceId:  replaceId,
+				}
+				if err := region.Create(db); err != nil {
+					return fmt.Errorf("special_region Create %s error:%v", town.FullCode, err)
+				}
+			}
+			replaceIds[town.FullCode] = replaceId
+		}
+		town.Code = city.Code*100 + replaceId
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"China_area_data/models"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 济南市和不设区县的东莞市，东莞市下属的镇按 towns 中的顺序
+func dongguanRelease(towns ...County) []Province {
+	return []Province{
+		{Code: 37, FullCode: "370000000000", Name: "山东省", Cities: []City{
+			{Code: 3701, FullCode: "370100000000", Name: "济南市", Counties: []County{{Code: 370102, FullCode: "370102000000", Name: "历下区"}}},
+		}},
+		{Code: 44, FullCode: "440000000000", Name: "广东省", Cities: []City{
+			{Code: 4419, FullCode: "441900000000", Name: "东莞市", TownAsCounty: true, Counties: towns},
+		}},
+	}
+}
+
+func dongguanTown(fullCode string, name string) County {
+	return County{Code: 441900, FullCode: fullCode, Name: name}
+}
+
+// 各区县的代码，按 full_code 索引
+func countyCodes(provinces []Province) map[string]int {
+	codes := make(map[string]int)
+	for _, p := range provinces {
+		for _, city := range p.Cities {
+			for _, county := range city.Counties {
+				codes[county.FullCode] = county.Code
+			}
+		}
+	}
+	return codes
+}
+
+func TestAssignSpecialRegionCodes(t *testing.T) {
+	db := openTestDB(t)
+	first := dongguanRelease(dongguanTown("441900003000", "东城街道"), dongguanTown("441900004000", "南城街道"))
+	if err := AssignSpecialRegionCodes(db, first); err != nil {
+		t.Fatalf("AssignSpecialRegionCodes() error = %v", err)
+	}
+	want := map[string]int{"370102000000": 370102, "441900003000": 441901, "441900004000": 441902}
+	if got := countyCodes(first); !reflect.DeepEqual(got, want) {
+		t.Errorf("first release codes = %v, want %v", got, want)
+	}
+
+	// 之后的版本沿用已分配的 replace_id，新出现的镇继续递增，即使排在前面
+	second := dongguanRelease(dongguanTown("441900001000", "莞城街道"), dongguanTown("441900004000", "南城街道"), dongguanTown("441900003000", "东城街道"))
+	if err := AssignSpecialRegionCodes(db, second); err != nil {
+		t.Fatalf("second AssignSpecialRegionCodes() error = %v", err)
+	}
+	want = map[string]int{"370102000000": 370102, "441900001000": 441903, "441900003000": 441901, "441900004000": 441902}
+	if got := countyCodes(second); !reflect.DeepEqual(got, want) {
+		t.Errorf("second release codes = %v, want %v", got, want)
+	}
+
+	regions := models.SpecialRegionList{}
+	if err := regions.GetByCityCode(db, "4419"); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0, len(regions))
+	for _, region := range regions {
+		got = append(got, fmt.Sprintf("%d %s %s", region.ReplaceId, region.ZoningCode, region.RegionName))
+	}
+	if want := "1 441900003000 东城街道,2 441900004000 南城街道,3 441900001000 莞城街道"; strings.Join(got, ",") != want {
+		t.Errorf("special_region = %v, want %s", got, want)
+	}
+}
+
+// 未配置数据库时按页面中的顺序分配，保证同一次抓取中不重复
+func TestAssignSpecialRegionCodesWithoutDB(t *testing.T) {
+	provinces := dongguanRelease(dongguanTown("441900001000", "莞城街道"), dongguanTown("441900003000", "东城街道"))
+	if err := AssignSpecialRegionCodes(nil, provinces); err != nil {
+		t.Fatalf("AssignSpecialRegionCodes() error = %v", err)
+	}
+	want := map[string]int{"370102000000": 370102, "441900001000": 441901, "441900003000": 441902}
+	if got := countyCodes(provinces); !reflect.DeepEqual(got, want) {
+		t.Errorf("codes = %v, want %v", got, want)
+	}
+}
+
+func TestAssignSpecialRegionCodesTooManyTowns(t *testing.T) {
+	towns := make([]County, 0, 100)
+	for i := 1; i <= 100; i++ {
+		towns = append(towns, dongguanTown(fmt.Sprintf("441900%03d000", i), fmt.Sprintf("第%d镇", i)))
+	}
+	if err := AssignSpecialRegionCodes(nil, dongguanRelease(towns...)); err == nil || !strings.Contains(err.Error(), "超过99个") {
+		t.Errorf("AssignSpecialRegionCodes() error = %v, want too many towns", err)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"China_area_data/models"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"sort"
+	"strconv"
+)
+
+// 根据两个版本之间的变更生成新旧代码对照关系，effectiveDate 为新版本的发布日期
+// 变更代码和变更隶属直接对应到新代码；撤销的区划由其下级划归的新上级继承，如莱芜市撤销后由济南市继承
+// 撤销后没有下级被划走的区划记录一条新代码为空的对照，表示没有继承；新增的区划记录一条旧代码为空的对照
+// 代码没有变化的变更隶属不需要对照；旧版本中重复的代码(如旧数据文件中东莞市下属的镇)无法区分，不生成对照
+func BuildCodeSuccessors(diff ReleaseDiff, effectiveDate string) models.CodeSuccessorList {
+	successors := make(models.CodeSuccessorList, 0)
+	// 旧上级代码 -> 下级变更后所属的新上级代码
+	inheritors := make(map[string][]string)
+	for _, change := range diff.Changes {
+		if change.Type != ChangeRecoded && change.Type != ChangeReparented {
+			continue
+		}
+		if change.OldParentCode != change.NewParentCode && change.NewParentCode != "" {
+			inheritors[change.OldParentCode] = appendUnique(inheritors[change.OldParentCode], change.NewParentCode)
+		}
+		if change.OldCode == change.NewCode || change.OldCodeDuplicated {
+			continue
+		}
+		successors = append(successors, models.CodeSuccessor{
+			Level:         change.Level,
+			OldCode:       change.OldCode,
+			NewCode:       change.NewCode,
+			ChangeType:    change.Type,
+			EffectiveDate: effectiveDate,
+		})
+	}
+	for _, change := range diff.Changes {
+		switch {
+		case change.Type == ChangeAdded:
+			successors = append(successors, models.CodeSuccessor{
+				Level:         change.Level,
+				NewCode:       change.NewCode,
+				ChangeType:    ChangeAdded,
+				EffectiveDate: effectiveDate,
+			})
+		case change.Type == ChangeAbolished && !change.OldCodeDuplicated:
+			newCodes := inheritors[change.OldCode]
+			if len(newCodes) == 0 {
+				newCodes = []string{""}
+			}
+			for _, newCode := range newCodes {
+				successors = append(successors, models.CodeSuccessor{
+					Level:         change.Level,
+					OldCode:       change.OldCode,
+					NewCode:       newCode,
+					ChangeType:    ChangeAbolished,
+					EffectiveDate: effectiveDate,
+				})
+			}
+		}
+	}
+	return successors
+}
+
+func appendUnique(codes []string, code string) []string {
+	for _, c := range codes {
+		if c == code {
+			return codes
+		}
+	}
+	return append(codes, code)
+}
+
+// 将对照关系写入 code_successor 表，已存在的不重复写入
+func SaveCodeSuccessors(db *gorm.DB, successors models.CodeSuccessorList) error {
+	for i := range successors {
+		if err := successors[i].Create(db); err != nil {
+			return fmt.Errorf("code_successor Create %s -> %s error:%v", successors[i].OldCode, successors[i].NewCode, err)
+		}
+	}
+	return nil
+}
+
+// 将历史区划代码升级为当前代码，同一层级内沿对照关系追溯
+type CodeResolver struct {
+	// 层级:旧代码 -> 按生效版本先后排列的对照关系
+	successors map[string]models.CodeSuccessorList
+	// 层级:代码 -> 最近一次作为新代码出现的生效版本
+	entered map[string]string
+}
+
+// 由对照关系构建，同一代码的对照关系按生效版本先后追溯
+func NewCodeResolver(successors models.CodeSuccessorList) *CodeResolver {
+	r := &CodeResolver{
+		successors: make(map[string]models.CodeSuccessorList),
+		entered:    make(map[string]string),
+	}
+	for _, s := range successors {
+		level := s.Level
+		if level == "" {
+			// 早期写入的记录按代码推断层级，新旧代码层级不同的是由重复的旧代码生成的，不可信
+			if level = fullCodeLevel(s.OldCode); s.OldCode == "" {
+				level = fullCodeLevel(s.NewCode)
+			} else if s.NewCode != "" && fullCodeLevel(s.NewCode) != level {
+				continue
+			}
+		}
+		if s.OldCode != "" && s.OldCode != s.NewCode {
+			key := successorKey(level, s.OldCode)
+			r.successors[key] = append(r.successors[key], s)
+		}
+		if s.NewCode != "" {
+			if key := successorKey(level, s.NewCode); s.EffectiveDate > r.entered[key] {
+				r.entered[key] = s.EffectiveDate
+			}
+		}
+	}
+	for _, list := range r.successors {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].EffectiveDate < list[j].EffectiveDate
+		})
+	}
+	return r
+}
+
+func successorKey(level string, fullCode string) string {
+	return level + ":" + fullCode
+}
+
+// 从 code_successor 表加载全部对照关系
+func LoadCodeResolver(db *gorm.DB) (*CodeResolver, error) {
+	successors := models.CodeSuccessorList{}
+	if err := successors.GetAll(db); err != nil {
+		return nil, err
+	}
+	return NewCodeResolver(successors), nil
+}
+
+// 沿对照关系一直追溯到当前仍然有效的代码，可能对应多个新代码
+// 2、4、6、9位的代码分别按省、市、区县、乡镇级补齐为12位，12位代码按末尾不为0的部分确定层级
+// 没有变更过的代码原样返回；撤销且没有继承的返回空；撤销后又在之后的版本中重新启用的代码本身也是有效的
+func (r *CodeResolver) Resolve(code string) []string {
+	level := inputCodeLevel(code)
+	result := make([]string, 0)
+	// since 为代码开始使用的版本，只沿之后生效的对照关系继续追溯
+	var walk func(code string, since string)
+	walk = func(code string, since string) {
+		key := successorKey(level, code)
+		next := ""
+		for _, s := range r.successors[key] {
+			if s.EffectiveDate <= since {
+				continue
+			}
+			// 同一版本中的对照关系一起追溯
+			if next != "" && s.EffectiveDate != next {
+				break
+			}
+			next = s.EffectiveDate
+			if s.NewCode != "" {
+				walk(s.NewCode, next)
+			}
+		}
+		switch entered := r.entered[key]; {
+		case next == "":
+			result = appendUnique(result, code)
+		case entered > next:
+			// 停用后在之后的版本中重新启用
+			walk(code, entered)
+		}
+	}
+	walk(padFullCode(code), "")
+	sort.Strings(result)
+	return result
+}
+
+// 将历史的6位区县代码升级为当前的6位区县代码
+func (r *CodeResolver) ResolveRegionCode(regionCode int) []int {
+	codes := make([]int, 0)
+	seen := make(map[int]bool)
+	for _, fullCode := range r.Resolve(strconv.Itoa(regionCode)) {
+		code, err := shortCode(fullCode, LevelCounty)
+		if err != nil || seen[code] {
+			continue
+		}
+		seen[code] = true
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+// 输入代码的层级，短代码按长度确定，12位代码按末尾不为0的部分确定
+func inputCodeLevel(code string) string {
+	switch len(code) {
+	case 2:
+		return "province"
+	case 4:
+		return "city"
+	case 6:
+		return "county"
+	case 9:
+		return "town"
+	}
+	return fullCodeLevel(padFullCode(code))
+}
+
+// 12位代码的层级，如 441900000000 为城市，441900003000 为乡镇
+func fullCodeLevel(fullCode string) string {
+	switch {
+	case len(fullCode) != fullCodeLength:
+		return ""
+	case fullCode[9:] != "000":
+		return "village"
+	case fullCode[6:9] != "000":
+		return "town"
+	case fullCode[4:6] != "00":
+		return "county"
+	case fullCode[2:4] != "00":
+		return "city"
+	}
+	return "province"
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"China_area_data/models"
+	"reflect"
+	"testing"
+)
+
+// 按发布先后排列的几个版本，生成各版本之间的对照关系
+func successorsOf(releases ...[]Province) models.CodeSuccessorList {
+	dates := []string{"2019-01-31", "2020-02-25", "2020-11-06", "2021-10-31"}
+	successors := make(models.CodeSuccessorList, 0)
+	for i := 1; i < len(releases); i++ {
+		diff := DiffReleases(dates[i-1], releases[i-1], dates[i], releases[i])
+		successors = append(successors, BuildCodeSuccessors(diff, dates[i])...)
+	}
+	return successors
+}
+
+func shandongCities(cities ...City) []Province {
+	return []Province{{Code: 37, FullCode: "370000000000", Name: "山东省", Cities: cities}}
+}
+
+func TestCodeResolver(t *testing.T) {
+	lixia := County{Code: 370102, FullCode: "370102000000", Name: "历下区", Towns: []Town{
+		{Code: 370102001, FullCode: "370102001000", Name: "解放路街道"},
+	}}
+	zhangqiu := County{Code: 370181, FullCode: "370181000000", Name: "章丘市"}
+	laiwu := City{Code: 3712, FullCode: "371200000000", Name: "莱芜市", Counties: []County{
+		{Code: 371202, FullCode: "371202000000", Name: "莱城区"},
+		{Code: 371203, FullCode: "371203000000", Name: "钢城区"},
+	}}
+	release2018 := shandongCities(
+		City{Code: 3701, FullCode: "370100000000", Name: "济南市", Counties: []County{lixia, zhangqiu}},
+		laiwu,
+	)
+	// 莱芜市撤销，钢城区划归济南市，莱城区撤销后设立莱芜区；章丘市撤市设区并变更代码
+	// 解放路街道划归新设的历城区，代码不变
+	release2019 := shandongCities(City{Code: 3701, FullCode: "370100000000", Name: "济南市", Counties: []County{
+		{Code: 370102, FullCode: "370102000000", Name: "历下区"},
+		{Code: 370112, FullCode: "370112000000", Name: "历城区", Towns: []Town{
+			{Code: 370102001, FullCode: "370102001000", Name: "解放路街道"},
+		}},
+		{Code: 370114, FullCode: "370114000000", Name: "章丘市"},
+		{Code: 370116, FullCode: "370116000000", Name: "莱芜区"},
+		{Code: 370117, FullCode: "370117000000", Name: "钢城区"},
+	}})
+	// 之后的版本重新启用 371202
+	release2020 := shandongCities(
+		release2019[0].Cities[0],
+		City{Code: 3712, FullCode: "371200000000", Name: "某市", Counties: []County{
+			{Code: 371202, FullCode: "371202000000", Name: "某区"},
+		}},
+	)
+	// 旧数据文件中东莞市下属的镇作为第三级，代码均为 441900
+	dongguanOld := []Province{{Code: 44, Name: "广东省", Cities: []City{{Code: 4419, Name: "东莞市", Counties: []County{
+		{Code: 441900, Name: "东城街道"},
+		{Code: 441900, Name: "南城街道"},
+	}}}}}
+	dongguanNew := []Province{{Code: 44, FullCode: "440000000000", Name: "广东省", Cities: []City{{Code: 4419, FullCode: "441900000000", Name: "东莞市", Counties: []County{
+		{Code: 441901, FullCode: "441900003000", Name: "东城街道"},
+		{Code: 441902, FullCode: "441900004000", Name: "南城街道"},
+	}}}}}
+
+	tests := []struct {
+		name       string
+		successors models.CodeSuccessorList
+		code       string
+		want       []string
+	}{
+		{"laiwu merge", successorsOf(release2018, release2019), "3712", []string{"370100000000"}},
+		{"laiwu county reparented", successorsOf(release2018, release2019), "371203", []string{"370117000000"}},
+		{"recode", successorsOf(release2018, release2019), "370181", []string{"370114000000"}},
+		{"unchanged", successorsOf(release2018, release2019), "370102", []string{"370102000000"}},
+		{"same code move", successorsOf(release2018, release2019), "370102001", []string{"370102001000"}},
+		{"abolished without successor", successorsOf(release2018, release2019), "371202", []string{}},
+		{"abolished and reused", successorsOf(release2018, release2019, release2020), "371202", []string{"371202000000"}},
+		{"abolished city reused", successorsOf(release2018, release2019, release2020), "371200000000", []string{"370100000000", "371200000000"}},
+		{"duplicated legacy code", successorsOf(dongguanOld, dongguanNew), "4419", []string{"441900000000"}},
+		{"duplicated legacy county code", successorsOf(dongguanOld, dongguanNew), "441900", []string{"441900000000"}},
+		{"unknown code", successorsOf(release2018, release2019), "110101", []string{"110101000000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewCodeResolver(tt.successors)
+			if got := r.Resolve(tt.code); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve(%s) = %v, want %v\n%+v", tt.code, got, tt.want, tt.successors)
+			}
+		})
+	}
+}
+
+func TestBuildCodeSuccessorsChain(t *testing.T) {
+	jinan := func(counties ...County) []Province {
+		return shandongCities(City{Code: 3701, FullCode: "370100000000", Name: "济南市", Counties: counties})
+	}
+	successors := successorsOf(
+		jinan(County{Code: 370181, FullCode: "370181000000", Name: "章丘市"}),
+		jinan(County{Code: 370114, FullCode: "370114000000", Name: "章丘市"}),
+		jinan(County{Code: 370199, FullCode: "370199000000", Name: "章丘市"}),
+	)
+	r := NewCodeResolver(successors)
+	for _, code := range []string{"370181", "370114", "370199"} {
+		if got, want := r.Resolve(code), []string{"370199000000"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Resolve(%s) = %v, want %v", code, got, want)
+		}
+	}
+	if got, want := r.ResolveRegionCode(370181), []int{370199}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ResolveRegionCode(370181) = %v, want %v", got, want)
+	}
+}
+
+func TestResolveRegionCodeDeduplicates(t *testing.T) {
+	// 旧数据写入的对照关系，城市代码指向了下属的镇
+	r := NewCodeResolver(models.CodeSuccessorList{
+		{Level: "county", OldCode: "441900000000", NewCode: "441900003000", ChangeType: ChangeRecoded, EffectiveDate: "2020-11-06"},
+		{Level: "county", OldCode: "441900000000", NewCode: "441900004000", ChangeType: ChangeRecoded, EffectiveDate: "2020-11-06"},
+	})
+	if got, want := r.ResolveRegionCode(441900), []int{441900}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ResolveRegionCode(441900) = %v, want %v", got, want)
+	}
+}
+
+func TestLoadCodeResolver(t *testing.T) {
+	db := openTestDB(t)
+	successors := models.CodeSuccessorList{
+		{Level: "city", OldCode: "371200000000", NewCode: "370100000000", ChangeType: ChangeAbolished, EffectiveDate: "2020-02-25"},
+		{Level: "county", OldCode: "371202000000", ChangeType: ChangeAbolished, EffectiveDate: "2020-02-25"},
+		// 早期写入的记录没有层级
+		{OldCode: "370181000000", NewCode: "370114000000", ChangeType: ChangeRecoded, EffectiveDate: "2020-02-25"},
+		{OldCode: "441900000000", NewCode: "441900003000", ChangeType: ChangeRecoded, EffectiveDate: "2020-02-25"},
+	}
+	// 重复写入时跳过已有的对照关系
+	for i := 0; i < 2; i++ {
+		if err := SaveCodeSuccessors(db, successors); err != nil {
+			t.Fatalf("SaveCodeSuccessors() error = %v", err)
+		}
+	}
+	var count int
+	if err := db.Table("code_successor").Count(&count).Error; err != nil || count != len(successors) {
+		t.Fatalf("code_successor rows = %d, %v, want %d", count, err, len(successors))
+	}
+	r, err := LoadCodeResolver(db)
+	if err != nil {
+		t.Fatalf("LoadCodeResolver() error = %v", err)
+	}
+	tests := []struct {
+		code string
+		want []string
+	}{
+		{"3712", []string{"370100000000"}},
+		{"371202", []string{}},
+		{"370181", []string{"370114000000"}},
+		// 城市代码指向镇的早期记录被忽略
+		{"4419", []string{"441900000000"}},
+	}
+	for _, tt := range tests {
+		if got := r.Resolve(tt.code); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Resolve(%s) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
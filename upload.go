@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 上传类型
+const (
+	// 本地目录，可由 nginx 等静态服务提供下载
+	UploaderLocal = "local"
+	// S3 兼容的对象存储，如 AWS S3、MinIO
+	UploaderS3 = "s3"
+	// 阿里云 OSS
+	UploaderOSS = "oss"
+)
+
+// 上传结果，Url 写入 fetch_record.down_url，Checksum 写入 fetch_record.checksum
+type UploadResult struct {
+	Url string
+	// 文件内容的 SHA-256，格式为 sha256:十六进制串
+	Checksum string
+}
+
+// 导出的压缩包上传到哪里，name 为对象名(不含 UploadConfig.Prefix)
+type Uploader interface {
+	Upload(ctx context.Context, name string, data []byte) (UploadResult, error)
+}
+
+// 上传配置，Type 决定使用哪些字段
+type UploadConfig struct {
+	Type string `json:"type"`
+	// 对象名前缀，如 china_area/
+	Prefix string `json:"prefix"`
+	// 下载链接的前缀，为空时 local 使用文件路径，s3、oss 使用 Endpoint 和 Bucket 拼出的地址
+	PublicUrl string `json:"public_url"`
+
+	// local：保存目录
+	Dir string `json:"dir"`
+
+	// s3、oss：服务地址，如 https://s3.amazonaws.com、http://127.0.0.1:9000、https://oss-cn-hangzhou.aliyuncs.com
+	Endpoint        string `json:"endpoint"`
+	Bucket          string `json:"bucket"`
+	AccessKeyId     string `json:"access_key_id"`
+	AccessKeySecret string `json:"access_key_secret"`
+	// s3：签名使用的区域，为空时为 us-east-1
+	Region string `json:"region"`
+	// s3：使用 Endpoint/Bucket/对象名 形式的地址，MinIO 等通常需要开启
+	PathStyle bool `json:"path_style"`
+}
+
+// 未配置上传时保存到本地 导出 目录
+var DefaultUploadConfig = UploadConfig{
+	Type: UploaderLocal,
+	Dir:  "./导出",
+}
+
+// 读取上传配置文件(JSON)
+func LoadUploadConfig(fileName string) (UploadConfig, error) {
+	config := UploadConfig{}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("parse %s error:%v", fileName, err)
+	}
+	return config, nil
+}
+
+// 按配置创建上传实现
+func NewUploader(config UploadConfig) (Uploader, error) {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	switch config.Type {
+	case UploaderLocal:
+		if config.Dir == "" {
+			return nil, fmt.Errorf("local 上传缺少 dir")
+		}
+		return &LocalUploader{Dir: config.Dir, Prefix: config.Prefix, PublicUrl: config.PublicUrl}, nil
+	case UploaderS3, UploaderOSS:
+		for _, field := range []struct{ name, value string }{
+			{"endpoint", config.Endpoint},
+			{"bucket", config.Bucket},
+			{"access_key_id", config.AccessKeyId},
+			{"access_key_secret", config.AccessKeySecret},
+		} {
+			if field.value == "" {
+				return nil, fmt.Errorf("%s 上传缺少 %s", config.Type, field.name)
+			}
+		}
+		endpoint, err := url.Parse(config.Endpoint)
+		if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+			return nil, fmt.Errorf("endpoint %s 格式错误", config.Endpoint)
+		}
+		if config.Type == UploaderOSS {
+			return &OSSUploader{config: config, endpoint: endpoint, client: client}, nil
+		}
+		if config.Region == "" {
+			config.Region = "us-east-1"
+		}
+		return &S3Uploader{config: config, endpoint: endpoint, client: client}, nil
+	default:
+		return nil, fmt.Errorf("未知的上传类型 %s", config.Type)
+	}
+}
+
+// 保存到本地目录
+type LocalUploader struct {
+	Dir       string
+	Prefix    string
+	PublicUrl string
+}
+
+func (u *LocalUploader) Upload(ctx context.Context, name string, data []byte) (UploadResult, error) {
+	key := u.Prefix + name
+	fileName := filepath.Join(u.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return UploadResult{}, err
+	}
+	// 先写临时文件再改名，下载方不会读到写了一半的文件
+	tmpName := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmpName, data, 0644); err != nil {
+		return UploadResult{}, err
+	}
+	if err := os.Rename(tmpName, fileName); err != nil {
+		os.Remove(tmpName)
+		return UploadResult{}, err
+	}
+	downUrl := fileName
+	if u.PublicUrl != "" {
+		downUrl = joinUrl(u.PublicUrl, key)
+	}
+	return UploadResult{Url: downUrl, Checksum: sha256Checksum(data)}, nil
+}
+
+// S3 兼容的对象存储，使用 AWS Signature Version 4 签名的 PUT 请求上传
+type S3Uploader struct {
+	config   UploadConfig
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func (u *S3Uploader) Upload(ctx context.Context, name string, data []byte) (UploadResult, error) {
+	key := u.config.Prefix + name
+	objectUrl := *u.endpoint
+	if u.config.PathStyle {
+		objectUrl.Path = path.Join("/", objectUrl.Path, u.config.Bucket, key)
+	} else {
+		objectUrl.Host = u.config.Bucket + "." + objectUrl.Host
+		objectUrl.Path = path.Join("/", objectUrl.Path, key)
+	}
+	req, err := http.NewRequest(http.MethodPut, objectUrl.String(), bytes.NewReader(data))
+	if err != nil {
+		return UploadResult{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/zip")
+	u.sign(req, data, time.Now().UTC())
+	if err := doUpload(u.client, req); err != nil {
+		return UploadResult{}, err
+	}
+	downUrl := objectUrl.String()
+	if u.config.PublicUrl != "" {
+		downUrl = joinUrl(u.config.PublicUrl, key)
+	}
+	return UploadResult{Url: downUrl, Checksum: sha256Checksum(data)}, nil
+}
+
+// 按 AWS Signature Version 4 为请求添加 Authorization 等请求头，签名包含 host 和请求中已有的全部请求头
+func (u *S3Uploader) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	canonicalRequest, signedHeaders := s3CanonicalRequest(req, payloadHash)
+	scope := date + "/" + u.config.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+u.config.AccessKeySecret), date)
+	key = hmacSHA256(key, u.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		u.config.AccessKeyId, scope, signedHeaders, signature))
+}
+
+// SigV4 的规范请求及参与签名的请求头列表
+func s3CanonicalRequest(req *http.Request, payloadHash string) (canonicalRequest string, signedHeaders string) {
+	values := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		header := strings.ToLower(name)
+		if header != "authorization" {
+			values[header] = strings.Join(strings.Fields(req.Header.Get(name)), " ")
+		}
+	}
+	headers := make([]string, 0, len(values))
+	for header := range values {
+		headers = append(headers, header)
+	}
+	sort.Strings(headers)
+	var canonicalHeaders strings.Builder
+	for _, header := range headers {
+		canonicalHeaders.WriteString(header + ":" + values[header] + "\n")
+	}
+	// 查询参数按名称排序，空格编码为 %20
+	query := strings.Replace(req.URL.Query().Encode(), "+", "%20", -1)
+	signedHeaders = strings.Join(headers, ";")
+	canonicalRequest = strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		query,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	return canonicalRequest, signedHeaders
+}
+
+// 按 SigV4 的要求编码路径，除字母、数字、-._~ 和 / 以外的字节都编码为 %XX
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	if b.Len() == 0 {
+		return "/"
+	}
+	return b.String()
+}
+
+// 阿里云 OSS，使用 OSS 签名(HMAC-SHA1)的 PUT 请求上传
+type OSSUploader struct {
+	config   UploadConfig
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func (u *OSSUploader) Upload(ctx context.Context, name string, data []byte) (UploadResult, error) {
+	key := u.config.Prefix + name
+	objectUrl := *u.endpoint
+	objectUrl.Host = u.config.Bucket + "." + objectUrl.Host
+	objectUrl.Path = "/" + key
+	req, err := http.NewRequest(http.MethodPut, objectUrl.String(), bytes.NewReader(data))
+	if err != nil {
+		return UploadResult{}, err
+	}
+	req = req.WithContext(ctx)
+	md5Sum := md5.Sum(data)
+	contentMD5 := base64.StdEncoding.EncodeToString(md5Sum[:])
+	date := time.Now().UTC().Format(http.TimeFormat)
+	req.Header.Set("Content-Type", "application/zip")
+	req.Header.Set("Content-MD5", contentMD5)
+	req.Header.Set("Date", date)
+	// 签名内容：VERB、Content-MD5、Content-Type、Date、CanonicalizedResource，没有 x-oss- 请求头
+	stringToSign := strings.Join([]string{http.MethodPut, contentMD5, "application/zip", date, "/" + u.config.Bucket + "/" + key}, "\n")
+	mac := hmac.New(sha1.New, []byte(u.config.AccessKeySecret))
+	mac.Write([]byte(stringToSign))
+	req.Header.Set("Authorization", "OSS "+u.config.AccessKeyId+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	if err := doUpload(u.client, req); err != nil {
+		return UploadResult{}, err
+	}
+	downUrl := objectUrl.String()
+	if u.config.PublicUrl != "" {
+		downUrl = joinUrl(u.config.PublicUrl, key)
+	}
+	return UploadResult{Url: downUrl, Checksum: sha256Checksum(data)}, nil
+}
+
+// 发送上传请求，非 2xx 时返回响应中的错误信息
+func doUpload(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("upload %s error: %s %s", req.URL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func joinUrl(base string, key string) string {
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(key, "/")
+}
+
+func sha256Checksum(data []byte) string {
+	return "sha256:" + sha256Hex(data)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"China_area_data/clog"
+	"China_area_data/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+// 发现新版本后的处理，默认为 IngestHandler(db, nil)，抓取该版本并导出压缩包，导出时写入 fetch_record
+type ReleaseHandler func(ctx context.Context, record PublishRecord) error
+
+// 定时检查国家统计局是否有新的发布记录，有新版本时调用 handler，直到 ctx 结束
+// 单次检查或处理失败只记录日志，下一个周期继续检查
+func (s *CrawlerSession) Watch(ctx context.Context, db *gorm.DB, interval time.Duration, handler ReleaseHandler) error {
+	if handler == nil {
+		handler = s.IngestHandler(db, nil)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		record, found, err := s.CheckNewRelease(ctx, db)
+		if err != nil {
+			clog.Logger.Error("CheckNewRelease err: %v", err)
+		} else if found {
+			logger := clog.Logger.With(clog.Fields{"year": record.Year, "date": record.Date})
+			logger.Info("new release found")
+			if err := handler(ctx, record); err != nil {
+				logger.Error("handle new release err: %v", err)
+			} else {
+				logger.Info("new release ingested")
+			}
+		} else {
+			clog.Logger.Debug("no new release")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// 比较最新发布记录的发布日期与 fetch_record 中最近一次导出的发布日期，发布日期更新时返回该记录
+func (s *CrawlerSession) CheckNewRelease(ctx context.Context, db *gorm.DB) (record PublishRecord, found bool, err error) {
+	publishRecords, err := s.GetPublishRecord(ctx)
+	if err != nil {
+		return record, false, err
+	}
+	if len(publishRecords) == 0 {
+		return record, false, errors.New("没有发布记录")
+	}
+	newest := publishRecords[0]
+	fetchRecord := models.FetchRecord{}
+	// 还没有导出过时 fetch_record 为空，UpdateAt 为空字符串
+	if err := fetchRecord.GetNewestFetchRecord(db); err != nil && !gorm.IsRecordNotFoundError(err) {
+		return record, false, err
+	}
+	// 发布日期格式为 2020-11-06，可以直接按字符串比较
+	if newest.Date <= fetchRecord.UpdateAt {
+		return record, false, nil
+	}
+	return newest, true, nil
+}
+
+// 默认的新版本处理：抓取数据，全部页面成功后导入 province_city_region 并导出，部分页面失败时不导入，等待下一个周期重试
+// 导出时从 db 读取数据并写入 fetch_record，导出成功后通过 notifier 发送 webhook 通知，notifier 为空时不通知
+func (s *CrawlerSession) IngestHandler(db *gorm.DB, notifier *WebhookNotifier) ReleaseHandler {
+	return func(ctx context.Context, record PublishRecord) error {
+		return s.ingestRelease(ctx, db, record, notifier)
+	}
+}
+
+func (s *CrawlerSession) ingestRelease(ctx context.Context, db *gorm.DB, record PublishRecord, notifier *WebhookNotifier) error {
+	// 先读出上一次抓取的数据用于统计变更，没有时不统计
+	const dataFile = "中国省市区数据"
+	previous, previousErr := readProvincesFile(dataFile)
+	current, partialErr, err := s.crawlRelease(ctx, record, "抓取失败记录")
+	if err != nil {
+		return err
+	}
+	// 部分页面抓取失败时保留上一次完整抓取的数据文件，也不导入，下一个周期重新抓取
+	if partialErr != nil {
+		return partialErr
+	}
+	chinaAreaData, _ := json.Marshal(current)
+	WriteWithIoutil(dataFile, chinaAreaData)
+	importResult, err := ImportRelease(db, current, record.Date)
+	if err != nil {
+		return err
+	}
+	clog.Logger.With(clog.Fields{"date": record.Date, "inserted": importResult.Inserted, "updated": importResult.Updated, "retired": importResult.Retired}).Info("release imported")
+	fetchRecord, err := ProvideMapDataZipFile(ctx, db, record.Date)
+	if err != nil {
+		return err
+	}
+	notification := ReleaseNotification{
+		Event:       WebhookEventReleaseIngested,
+		ReleaseYear: record.Year,
+		ReleaseDate: record.Date,
+		DownloadUrl: fetchRecord.DownUrl,
+		Checksum:    fetchRecord.Checksum,
+		UpdateTime:  fetchRecord.UpdateTime,
+		Import:      &importResult,
+	}
+	if previousErr == nil {
+		releaseDiff := DiffReleases("", previous, record.Date, current)
+		notification.Changes = releaseDiff.Counts()
+		notification.TotalChanges = len(releaseDiff.Changes)
+	}
+	// 数据已经导出，通知失败只影响下游，不会在下一个周期重新导入
+	if err := notifier.Notify(ctx, notification); err != nil {
+		return fmt.Errorf("release ingested but notify failed: %v", err)
+	}
+	return nil
+}
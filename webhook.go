@@ -0,0 +1,214 @@
+package main
+
+import (
+	"China_area_data/clog"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 新版本导入完成的事件名
+const WebhookEventReleaseIngested = "release_ingested"
+
+// webhook 请求头
+const (
+	webhookEventHeader     = "X-China-Area-Event"
+	webhookDeliveryHeader  = "X-China-Area-Delivery"
+	webhookTimestampHeader = "X-China-Area-Timestamp"
+	// 值为 sha256=签名，签名为以 Secret 为密钥对 "时间戳.请求体" 计算的 HMAC-SHA256 十六进制串
+	webhookSignatureHeader = "X-China-Area-Signature"
+)
+
+// 一个接收通知的地址
+type WebhookConfig struct {
+	Url string `json:"url"`
+	// 签名密钥，为空时不签名
+	Secret string `json:"secret"`
+	// 最多发送次数，为 0 时使用 DefaultWebhookMaxAttempts
+	MaxAttempts int `json:"max_attempts"`
+}
+
+// 默认最多发送次数
+const DefaultWebhookMaxAttempts = 5
+
+// 新版本导入完成的通知内容
+type ReleaseNotification struct {
+	Event string `json:"event"`
+	// 版本年份和发布日期
+	ReleaseYear string `json:"release_year"`
+	ReleaseDate string `json:"release_date"`
+	// 压缩包下载链接，即 fetch_record.down_url
+	DownloadUrl string `json:"download_url"`
+	// 压缩包的校验值，即 fetch_record.checksum，格式为 sha256:十六进制串
+	Checksum string `json:"checksum"`
+	// fetch_record 的写入时间
+	UpdateTime string `json:"update_time"`
+	// 与上一次抓取的数据相比的变更数量，键为 added、abolished、renamed、reparented、recoded，没有上一次的数据时为空
+	Changes      map[string]int `json:"changes"`
+	TotalChanges int            `json:"total_changes"`
+	// 写入 province_city_region 的新增、更新和撤销行数
+	Import *ImportResult `json:"import,omitempty"`
+}
+
+// 读取 webhook 配置文件，文件内容为 WebhookConfig 的 JSON 数组
+func LoadWebhookConfigs(fileName string) ([]WebhookConfig, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	hooks := make([]WebhookConfig, 0)
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, fmt.Errorf("parse %s error:%v", fileName, err)
+	}
+	for i, hook := range hooks {
+		if hook.Url == "" {
+			return nil, fmt.Errorf("%s 中第 %d 个 webhook 缺少 url", fileName, i+1)
+		}
+	}
+	return hooks, nil
+}
+
+// 向配置的地址发送通知，失败时按指数退避重试
+type WebhookNotifier struct {
+	hooks  []WebhookConfig
+	client *http.Client
+	// 第一次重试前的等待时间，之后每次翻倍，不超过 RetryMaxDelay
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+}
+
+func NewWebhookNotifier(hooks []WebhookConfig) *WebhookNotifier {
+	return &WebhookNotifier{
+		hooks:          hooks,
+		client:         &http.Client{Timeout: 10 * time.Second},
+		RetryBaseDelay: time.Second,
+		RetryMaxDelay:  time.Minute,
+	}
+}
+
+// 向所有地址发送通知，某个地址失败不影响其它地址，返回所有失败地址的错误
+func (n *WebhookNotifier) Notify(ctx context.Context, notification ReleaseNotification) error {
+	if n == nil || len(n.hooks) == 0 {
+		return nil
+	}
+	if notification.Event == "" {
+		notification.Event = WebhookEventReleaseIngested
+	}
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	// 同一次通知的各次重试使用相同的编号，接收方可据此去重
+	delivery := strconv.FormatInt(time.Now().UnixNano(), 36)
+	failed := make([]string, 0)
+	for _, hook := range n.hooks {
+		logger := clog.Logger.With(clog.Fields{"url": hook.Url, "event": notification.Event, "delivery": delivery})
+		if err := n.deliver(ctx, hook, notification.Event, delivery, body, logger); err != nil {
+			logger.Error("webhook failed: %v", err)
+			failed = append(failed, fmt.Sprintf("%s: %v", hook.Url, err))
+			continue
+		}
+		logger.Info("webhook delivered")
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d 个 webhook 发送失败：%s", len(failed), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// 发送到一个地址，网络错误、429 和 5xx 时重试，其它非 2xx 状态码直接失败
+func (n *WebhookNotifier) deliver(ctx context.Context, hook WebhookConfig, event, delivery string, body []byte, logger clog.Interface) error {
+	maxAttempts := hook.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = DefaultWebhookMaxAttempts
+	}
+	for attempt := 1; ; attempt++ {
+		retryable, err := n.post(ctx, hook, event, delivery, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= maxAttempts {
+			return err
+		}
+		delay := n.backoff(attempt)
+		logger.With(clog.Fields{"attempt": attempt, "max_attempts": maxAttempts}).Warn("webhook error: %v, retry after %v", err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (n *WebhookNotifier) post(ctx context.Context, hook WebhookConfig, event, delivery string, body []byte) (retryable bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, event)
+	req.Header.Set(webhookDeliveryHeader, delivery)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	if hook.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256="+SignWebhook(hook.Secret, timestamp, body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// ctx 结束时不再重试
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retryable = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retryable, fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+// 第 attempt 次失败后的等待时间，与抓取重试相同，在 [d/2, d] 之间随机
+func (n *WebhookNotifier) backoff(attempt int) time.Duration {
+	d := n.RetryBaseDelay << uint(attempt-1)
+	if max := n.RetryMaxDelay; max > 0 && (d > max || d <= 0) {
+		d = max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// 计算 webhook 签名，接收方用相同的密钥、X-China-Area-Timestamp 和原始请求体计算后比较
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 校验 webhook 签名，signature 为 X-China-Area-Signature 的值
+func VerifyWebhookSignature(secret, timestamp string, body []byte, signature string) error {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return errors.New("签名格式错误")
+	}
+	expected := SignWebhook(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.TrimPrefix(signature, "sha256="))) {
+		return errors.New("签名不匹配")
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 按顺序返回 statuses 中的状态码，之后都返回 200，记录收到的请求
+type webhookRecorder struct {
+	mu       sync.Mutex
+	statuses []int
+	headers  []http.Header
+	bodies   [][]byte
+}
+
+func (r *webhookRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.headers = append(r.headers, req.Header.Clone())
+	r.bodies = append(r.bodies, body)
+	status := http.StatusOK
+	if n := len(r.headers); n <= len(r.statuses) {
+		status = r.statuses[n-1]
+	}
+	w.WriteHeader(status)
+}
+
+func newTestNotifier(hooks []WebhookConfig) *WebhookNotifier {
+	n := NewWebhookNotifier(hooks)
+	n.RetryBaseDelay = time.Millisecond
+	n.RetryMaxDelay = 5 * time.Millisecond
+	return n
+}
+
+func TestWebhookSignature(t *testing.T) {
+	recorder := &webhookRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	const secret = "s3cret"
+	n := newTestNotifier([]WebhookConfig{{Url: server.URL, Secret: secret}})
+	notification := ReleaseNotification{ReleaseYear: "2020", ReleaseDate: "2020-11-06", DownloadUrl: "https://example.com/china_area_2020-11-06.zip"}
+	if err := n.Notify(context.Background(), notification); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if len(recorder.headers) != 1 {
+		t.Fatalf("got %d requests, want 1", len(recorder.headers))
+	}
+	header, body := recorder.headers[0], recorder.bodies[0]
+	if got := header.Get(webhookEventHeader); got != WebhookEventReleaseIngested {
+		t.Errorf("%s = %q, want %q", webhookEventHeader, got, WebhookEventReleaseIngested)
+	}
+	if header.Get(webhookDeliveryHeader) == "" {
+		t.Errorf("%s is empty", webhookDeliveryHeader)
+	}
+	if got := header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	timestamp, signature := header.Get(webhookTimestampHeader), header.Get(webhookSignatureHeader)
+	if err := VerifyWebhookSignature(secret, timestamp, body, signature); err != nil {
+		t.Errorf("VerifyWebhookSignature() error = %v", err)
+	}
+	var received ReleaseNotification
+	if err := json.Unmarshal(body, &received); err != nil {
+		t.Fatalf("unmarshal body error = %v", err)
+	}
+	if received.Event != WebhookEventReleaseIngested || received.ReleaseDate != notification.ReleaseDate {
+		t.Errorf("body = %+v", received)
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		signature string
+	}{
+		{"wrong secret", "other", timestamp, body, signature},
+		{"wrong timestamp", secret, "0", body, signature},
+		{"tampered body", secret, timestamp, append([]byte(" "), body...), signature},
+		{"missing prefix", secret, timestamp, body, SignWebhook(secret, timestamp, body)},
+		{"empty", secret, timestamp, body, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyWebhookSignature(tt.secret, tt.timestamp, tt.body, tt.signature); err == nil {
+				t.Error("VerifyWebhookSignature() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestWebhookWithoutSecret(t *testing.T) {
+	recorder := &webhookRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	if err := newTestNotifier([]WebhookConfig{{Url: server.URL}}).Notify(context.Background(), ReleaseNotification{}); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if got := recorder.headers[0].Get(webhookSignatureHeader); got != "" {
+		t.Errorf("%s = %q, want empty", webhookSignatureHeader, got)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		maxAttempts  int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"success", nil, 0, 1, false},
+		{"5xx retried", []int{500, 502, 503}, 0, 4, false},
+		{"429 retried", []int{429}, 0, 2, false},
+		{"5xx until max attempts", []int{500, 500, 500, 500}, 3, 3, true},
+		{"400 not retried", []int{400}, 0, 1, true},
+		{"401 not retried", []int{401}, 0, 1, true},
+		{"404 not retried", []int{404}, 0, 1, true},
+		{"4xx after 5xx not retried", []int{500, 410}, 0, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &webhookRecorder{statuses: tt.statuses}
+			server := httptest.NewServer(recorder)
+			defer server.Close()
+
+			n := newTestNotifier([]WebhookConfig{{Url: server.URL, Secret: "s3cret", MaxAttempts: tt.maxAttempts}})
+			err := n.Notify(context.Background(), ReleaseNotification{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Notify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(recorder.headers) != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", len(recorder.headers), tt.wantAttempts)
+			}
+			// 同一次通知的各次重试使用相同的编号
+			for _, header := range recorder.headers {
+				if header.Get(webhookDeliveryHeader) != recorder.headers[0].Get(webhookDeliveryHeader) {
+					t.Errorf("delivery changed between attempts")
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookRetryStopsOnCancel(t *testing.T) {
+	recorder := &webhookRecorder{statuses: []int{500, 500, 500}}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	n := NewWebhookNotifier([]WebhookConfig{{Url: server.URL}})
+	n.RetryBaseDelay = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := n.Notify(ctx, ReleaseNotification{}); err == nil {
+		t.Fatal("Notify() error = nil, want error")
+	}
+	if len(recorder.headers) != 1 {
+		t.Errorf("got %d attempts, want 1", len(recorder.headers))
+	}
+}